@@ -1,19 +1,23 @@
 """Billing and subscription management API endpoints"""
 
-from datetime import datetime
+from datetime import datetime, timezone
 from typing import Optional, Dict, Any
 from uuid import UUID
 
 import logging
 from fastapi import APIRouter, Depends, HTTPException, status, Query
 from pydantic import BaseModel, Field, field_validator
+from sqlalchemy import select
 from sqlalchemy.ext.asyncio import AsyncSession
 
+from app.config import config
 from app.database.postgres_models import User
 from app.core.auth_dependencies import get_current_user, get_db_session
-from app.services.billing_service import billing_service
+from app.services.billing_service import billing_service, EnhancedBillingService
+from app.dependencies import get_billing_service
 
-from app.core.auth_dependencies import get_current_active_user
+from app.core.auth_dependencies import get_current_active_user, get_admin_user
+from app.core.pagination import PagedResponse
 
 logger = logging.getLogger(__name__)
 
@@ -71,6 +75,7 @@ class SubscriptionResponse(BaseModel):
     limits: Dict[str, int]
     amount_cents: int
     currency: str
+    plan_version: Optional[str] = None
 
 
 class UsageResponse(BaseModel):
@@ -98,6 +103,40 @@ class QuotaResponse(BaseModel):
     period_end: str
 
 
+class QuotaOverrideRequest(BaseModel):
+    """Request model for granting a temporary quota override"""
+
+    resource_type: str = Field(
+        ..., description="Type of resource (messages, api_calls, etc.)"
+    )
+    max_allowed: int = Field(..., ge=0, description="Limit while the override lasts")
+    expires_at: datetime = Field(..., description="When the plan limit applies again")
+    reason: str = Field(..., min_length=1, max_length=500)
+
+    @field_validator("expires_at")
+    @classmethod
+    def validate_expires_at(cls, v):
+        if v.tzinfo is None:
+            v = v.replace(tzinfo=timezone.utc)
+        if v <= datetime.now(timezone.utc):
+            raise ValueError("expires_at must be in the future")
+        return v
+
+
+class QuotaOverrideResponse(BaseModel):
+    """Response model for a quota override"""
+
+    id: UUID
+    user_id: UUID
+    resource_type: str
+    max_allowed: int
+    kind: str
+    reason: str
+    granted_by: Optional[UUID]
+    expires_at: datetime
+    revoked_at: Optional[datetime]
+
+
 class BillingHistoryItem(BaseModel):
     """Response model for billing history item"""
 
@@ -139,6 +178,7 @@ async def get_subscription(
             or billing_service._get_plan_limits(subscription.plan_type),
             amount_cents=subscription.amount_cents,
             currency=subscription.currency,
+            plan_version=subscription.plan_version,
         )
     except Exception as e:
         raise HTTPException(
@@ -180,6 +220,7 @@ async def update_subscription(
             or billing_service._get_plan_limits(updated_subscription.plan_type),
             amount_cents=updated_subscription.amount_cents,
             currency=updated_subscription.currency,
+            plan_version=updated_subscription.plan_version,
         )
     except HTTPException:
         raise
@@ -215,6 +256,158 @@ async def cancel_subscription(
         )
 
 
+@router.post(
+    "/admin/subscriptions/{user_id}/migrate", response_model=SubscriptionResponse
+)
+async def migrate_subscription(
+    user_id: UUID,
+    admin_user: User = Depends(get_admin_user),
+    session: AsyncSession = Depends(get_db_session),
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> SubscriptionResponse:
+    """Move a user's grandfathered subscription onto the current plan definition (admin only)"""
+    result = await session.execute(select(User).where(User.id == user_id))
+    user = result.scalar_one_or_none()
+    if not user:
+        raise HTTPException(status_code=status.HTTP_404_NOT_FOUND, detail="User not found")
+
+    subscription = await billing.migrate_subscription_to_current_plan(user, session)
+    if not subscription:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="No active subscription to migrate",
+        )
+
+    logger.info(f"Admin {admin_user.email} migrated subscription for user {user.email}")
+
+    return SubscriptionResponse(
+        id=subscription.id,
+        plan_type=subscription.plan_type,
+        status=subscription.status,
+        billing_cycle=subscription.billing_cycle,
+        started_at=subscription.started_at,
+        ends_at=subscription.ends_at,
+        auto_renew=subscription.auto_renew,
+        limits=subscription.limits,
+        amount_cents=subscription.amount_cents,
+        currency=subscription.currency,
+        plan_version=subscription.plan_version,
+    )
+
+
+@router.post(
+    "/admin/users/{user_id}/quota-overrides",
+    response_model=QuotaOverrideResponse,
+    status_code=status.HTTP_201_CREATED,
+)
+async def grant_quota_override(
+    user_id: UUID,
+    override_request: QuotaOverrideRequest,
+    admin_user: User = Depends(get_admin_user),
+    session: AsyncSession = Depends(get_db_session),
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> QuotaOverrideResponse:
+    """Grant a user a temporary quota limit for one resource (admin only)"""
+    result = await session.execute(select(User).where(User.id == user_id))
+    user = result.scalar_one_or_none()
+    if not user:
+        raise HTTPException(status_code=status.HTTP_404_NOT_FOUND, detail="User not found")
+
+    override = await billing.grant_quota_override(
+        user,
+        override_request.resource_type,
+        override_request.max_allowed,
+        override_request.expires_at,
+        override_request.reason,
+        granted_by=admin_user,
+        session=session,
+    )
+
+    logger.info(
+        f"Admin {admin_user.email} granted {override.resource_type} override "
+        f"to user {user.email}"
+    )
+    return QuotaOverrideResponse.model_validate(override, from_attributes=True)
+
+
+@router.delete(
+    "/admin/quota-overrides/{override_id}", response_model=QuotaOverrideResponse
+)
+async def revoke_quota_override(
+    override_id: UUID,
+    admin_user: User = Depends(get_admin_user),
+    session: AsyncSession = Depends(get_db_session),
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> QuotaOverrideResponse:
+    """End a quota override before it expires (admin only)"""
+    override = await billing.revoke_quota_override(override_id, admin_user, session)
+    if not override:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND, detail="Quota override not found"
+        )
+
+    logger.info(f"Admin {admin_user.email} revoked quota override {override_id}")
+    return QuotaOverrideResponse.model_validate(override, from_attributes=True)
+
+
+@router.post("/admin/inactive-subscriptions/run")
+async def run_inactive_subscription_policy(
+    admin_user: User = Depends(get_admin_user),
+    session: AsyncSession = Depends(get_db_session),
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> Dict[str, Any]:
+    """Flag and downgrade unused paid subscriptions (admin only, cron-safe)"""
+    if not config.inactive_downgrade_enabled:
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT,
+            detail="Inactive subscription policy is disabled",
+        )
+
+    summary = await billing.process_inactive_subscriptions(
+        session,
+        inactive_days=config.inactive_downgrade_after_days,
+        grace_days=config.inactive_downgrade_grace_days,
+    )
+
+    logger.info(
+        f"Admin {admin_user.email} ran inactive subscription policy: "
+        f"{len(summary['flagged'])} flagged, {len(summary['downgraded'])} downgraded"
+    )
+    return summary
+
+
+@router.post("/admin/usage-anomalies/run")
+async def run_usage_anomaly_detection(
+    admin_user: User = Depends(get_admin_user),
+    session: AsyncSession = Depends(get_db_session),
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> Dict[str, Any]:
+    """Flag accounts with usage spikes against their history (admin only)"""
+    if not config.usage_anomaly_enabled:
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT,
+            detail="Usage anomaly detection is disabled",
+        )
+
+    summary = await billing.detect_usage_anomalies(
+        session,
+        spike_multiplier=config.usage_anomaly_multiplier,
+        baseline_periods=config.usage_anomaly_baseline_periods,
+        min_usage=config.usage_anomaly_min_usage,
+        throttle_hours=(
+            config.usage_anomaly_throttle_hours
+            if config.usage_anomaly_throttle
+            else None
+        ),
+    )
+
+    logger.info(
+        f"Admin {admin_user.email} ran usage anomaly detection: "
+        f"{len(summary['flagged'])} flagged"
+    )
+    return summary
+
+
 @router.get("/usage", response_model=UsageResponse)
 async def get_usage_summary(
     current_user: User = Depends(get_current_active_user),
@@ -285,25 +478,23 @@ async def check_quota(
         )
 
 
-@router.get("/history")
+@router.get("/history", response_model=PagedResponse[Dict[str, Any]])
 async def get_billing_history(
     limit: int = Query(default=10, ge=1, le=100),
     offset: int = Query(default=0, ge=0),
     current_user: User = Depends(get_current_active_user),
     session: AsyncSession = Depends(get_db_session),
-) -> Dict[str, Any]:
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> PagedResponse[Dict[str, Any]]:
     """Get billing history for user"""
     try:
-        history = await billing_service.get_billing_history(
+        history = await billing.get_billing_history(
             current_user, session, limit=limit, offset=offset
         )
 
-        return {
-            "total": history["total"],
-            "items": history["items"],
-            "limit": limit,
-            "offset": offset,
-        }
+        return PagedResponse[Dict[str, Any]].build(
+            history["items"], history["total"], limit, offset
+        )
     except Exception as e:
         raise HTTPException(
             status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
@@ -312,9 +503,11 @@ async def get_billing_history(
 
 
 @router.get("/plans")
-async def get_available_plans() -> Dict[str, Any]:
+async def get_available_plans(
+    billing: EnhancedBillingService = Depends(get_billing_service),
+) -> Dict[str, Any]:
     """Get all available subscription plans with pricing"""
-    return billing_service.get_available_plans()
+    return billing.get_available_plans()
 
 
 @router.get("/usage/details")
@@ -7,25 +7,36 @@ from typing import Any, Dict, List, Optional
 from uuid import uuid4
 import logging
 
-from fastapi import APIRouter, Depends, HTTPException, Query, BackgroundTasks, status
+from fastapi import (
+    APIRouter,
+    Depends,
+    HTTPException,
+    Query,
+    Request,
+    Response,
+    status,
+)
 from pydantic import BaseModel, Field
+from sqlalchemy.ext.asyncio import AsyncSession
 
 # Import authentication dependencies
 from app.core.auth_dependencies import (
     get_current_active_user,
     check_message_quota,
+    check_search_quota,
     RateLimiter,
 )
+from app.core.overload import ServiceOverloadedError
+from app.core.pagination import PagedResponse
 from app.database.postgres_models import User
 
 from app.dependencies import (
     get_chatbot_service,
     get_knowledge_service,
-    get_billing_service,
+    get_db_session,
 )
 from app.services.chatbot_service import EnhancedChatbotService as ChatbotService
 from app.services.knowledge_service import KnowledgeService
-from app.services.billing_service import EnhancedBillingService
 
 logger = logging.getLogger(__name__)
 
@@ -109,63 +120,57 @@ class ChatResponse(BaseModel):
     subscription_plan: str
     usage_info: Dict[str, Any]
 
+    # Set when an AI service was overloaded and the answer is a placeholder
+    retry_after_seconds: Optional[int] = None
+
     # Debug Information
     debug_info: Optional[Dict[str, Any]] = None
 
 
-async def record_usage_background(
-    user: User,
-    resource_type: str,
-    quantity: int,
-    metadata: Dict[str, Any],
-    billing_service: EnhancedBillingService,
-):
-    """Background task to record usage"""
-    try:
-        success = await billing_service.record_usage(
-            user=user, resource_type=resource_type, quantity=quantity, metadata=metadata
-        )
-
-        if success:
-            logger.info(
-                f"Usage recorded for user {user.id}: {resource_type} x{quantity}"
-            )
-        else:
-            logger.error(f"Failed to record usage for user {user.id}")
-
-    except Exception as e:
-        logger.error(f"Error recording usage: {e}")
-
-
 @router.post("/message", response_model=ChatResponse)
 async def send_chat_message(
     request: ChatRequest,
-    background_tasks: BackgroundTasks,
-    current_user: User = Depends(check_message_quota),  # Quota check included
+    http_request: Request,
+    response: Response,
     _rate_limit: User = Depends(chat_rate_limiter),  # Rate limiting
+    current_user: User = Depends(check_message_quota),  # Quota check
     chatbot: ChatbotService = Depends(get_chatbot_service),
     knowledge_service: KnowledgeService = Depends(get_knowledge_service),
-    billing_service: EnhancedBillingService = Depends(get_billing_service),
+    session: AsyncSession = Depends(get_db_session),
 ) -> ChatResponse:
     """
     Send a chat message with RAG support.
 
     Protected endpoint that:
     1. Requires authentication
-    2. Checks message quota before processing
-    3. Applies rate limiting
-    4. Records usage for billing
+    2. Applies rate limiting
+    3. Reserves message quota atomically once the request is valid
+    4. Reserves an API call the same way when RAG retrieval runs
+
+    If an AI service is overloaded, returns a degraded answer with Retry-After
+    and releases the reserved message quota. The message is not queued and no
+    completion notification is sent; the client retries after Retry-After.
     """
     start_time = time.time()
     session_id = request.session_id or str(uuid4())
     message_id = str(uuid4())
 
+    # Only charge once rate limiting and body validation have passed
+    await check_message_quota.reserve(http_request, current_user, session)
+
+    succeeded = False
+    failure_reason = "chat_failed"
+    rag_reserved = False
     try:
         # Prepare context if RAG is enabled
         context = None
         sources = []
 
         if request.enable_rag and knowledge_service:
+            # Retrieval is billed as an API call, reserved like the message
+            await check_search_quota.reserve(http_request, current_user, session)
+            rag_reserved = True
+
             # Perform RAG search
             search_results = await knowledge_service.search_router(
                 query=request.message,
@@ -208,37 +213,10 @@ async def send_chat_message(
         # Calculate processing time
         processing_time_ms = (time.time() - start_time) * 1000
 
-        # Get current usage for response
-        quota_info = await billing_service.check_user_quota(current_user, "messages")
-
-        # Record usage in background
-        background_tasks.add_task(
-            record_usage_background,
-            user=current_user,
-            resource_type="messages",
-            quantity=1,
-            metadata={
-                "session_id": session_id,
-                "message_id": message_id,
-                "tokens_used": tokens_used,
-                "processing_time_ms": processing_time_ms,
-                "rag_enabled": request.enable_rag,
-            },
-            billing_service=billing_service,
-        )
-
-        # If RAG was used, also record API call
-        if request.enable_rag and sources:
-            background_tasks.add_task(
-                record_usage_background,
-                user=current_user,
-                resource_type="api_calls",
-                quantity=1,
-                metadata={"type": "rag_search", "session_id": session_id},
-                billing_service=billing_service,
-            )
+        # The message was already recorded when the quota was reserved
+        quota_info = check_message_quota.reservation(http_request)
 
-        return ChatResponse(
+        chat_response = ChatResponse(
             session_id=session_id,
             message_id=message_id,
             answer=answer,
@@ -251,9 +229,9 @@ async def send_chat_message(
             tokens_used=tokens_used,
             subscription_plan=current_user.subscription_plan,
             usage_info={
-                "messages_used": quota_info["current_usage"],
-                "messages_limit": quota_info["max_allowed"],
-                "messages_remaining": quota_info["remaining"],
+                "messages_used": quota_info.get("current_usage"),
+                "messages_limit": quota_info.get("max_allowed"),
+                "messages_remaining": quota_info.get("remaining"),
             },
             debug_info={
                 "user_id": str(current_user.id),
@@ -263,7 +241,34 @@ async def send_chat_message(
             if request.debug_mode
             else None,
         )
+        succeeded = True
+        return chat_response
+
+    except ServiceOverloadedError as e:
+        logger.warning(f"Chat degraded for user {current_user.id}: {e}")
+        response.headers["Retry-After"] = str(e.retry_after)
+
+        # Nothing was answered, so the reservation is released below
+        failure_reason = f"{e.service}_overloaded"
+        answer = (
+            "We're handling a lot of requests right now and couldn't answer "
+            f"your message. Please try again in {e.retry_after} seconds."
+        )
 
+        # Usage counts are left out: the reservation they describe is
+        # released in the finally block below
+        return ChatResponse(
+            session_id=session_id,
+            message_id=message_id,
+            answer=answer,
+            confidence=0.0,
+            response_type="degraded",
+            context_used=False,
+            response_time_ms=(time.time() - start_time) * 1000,
+            subscription_plan=current_user.subscription_plan,
+            usage_info={"charged": False},
+            retry_after_seconds=e.retry_after,
+        )
     except HTTPException:
         raise
     except Exception as e:
@@ -272,29 +277,45 @@ async def send_chat_message(
             status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
             detail="Failed to process chat message",
         )
+    finally:
+        # Don't charge the user for a request that produced nothing
+        await check_message_quota.settle(
+            http_request, current_user, session, succeeded, reason=failure_reason
+        )
+        if rag_reserved:
+            await check_search_quota.settle(
+                http_request, current_user, session, succeeded, reason=failure_reason
+            )
+
+
+class ChatHistoryResponse(PagedResponse[Dict[str, Any]]):
+    """Page of chat messages for a user"""
 
+    user_id: str
+    session_id: Optional[str] = None
 
-@router.get("/history")
+
+@router.get("/history", response_model=ChatHistoryResponse)
 async def get_chat_history(
     session_id: Optional[str] = None,
     limit: int = Query(default=20, ge=1, le=100),
     offset: int = Query(default=0, ge=0),
     current_user: User = Depends(get_current_active_user),
-) -> Dict[str, Any]:
+) -> ChatHistoryResponse:
     """
     Get chat history for the current user.
 
     Protected endpoint that retrieves conversation history from ScyllaDB.
     """
     try:
-        return {
-            "user_id": str(current_user.id),
-            "session_id": session_id,
-            "messages": [],
-            "total": 0,
-            "limit": limit,
-            "offset": offset,
-        }
+        return ChatHistoryResponse.build(
+            [],
+            0,
+            limit,
+            offset,
+            user_id=str(current_user.id),
+            session_id=session_id,
+        )
 
     except Exception as e:
         logger.error(f"Failed to get chat history: {e}")
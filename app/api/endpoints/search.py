@@ -6,8 +6,16 @@ import time
 from typing import Any, Dict, List, Optional
 import logging
 
-from fastapi import APIRouter, Depends, HTTPException, Query, BackgroundTasks, status
+from fastapi import (
+    APIRouter,
+    Depends,
+    HTTPException,
+    Query,
+    Request,
+    status,
+)
 from pydantic import BaseModel, Field
+from sqlalchemy.ext.asyncio import AsyncSession
 
 # Import authentication dependencies
 from app.core.auth_dependencies import (
@@ -15,11 +23,15 @@ from app.core.auth_dependencies import (
     check_search_quota,
     RateLimiter,
 )
+from app.config import config
+from app.core.overload import ServiceOverloadedError
 from app.database.postgres_models import User
 
-from app.dependencies import get_knowledge_service, get_billing_service
+from app.dependencies import (
+    get_knowledge_service,
+    get_db_session,
+)
 from app.services.knowledge_service import KnowledgeService
-from app.services.billing_service import EnhancedBillingService
 
 logger = logging.getLogger(__name__)
 
@@ -30,6 +42,17 @@ search_rate_limiter = RateLimiter(
     calls=60, period=60, resource="search"
 )  # 60 searches per minute
 
+# Search routes each subscription plan may use
+PLAN_SEARCH_ROUTES = {
+    "free": ["exact", "auto"],
+    "pro": ["exact", "semantic", "hybrid", "auto"],
+    "enterprise": ["exact", "semantic", "hybrid", "auto"],
+}
+
+# Result metrics that measure keyword relevance vs embedding similarity
+LEXICAL_METRICS = {"textScore", "regex_match", "exact_match", "keyword_overlap"}
+VECTOR_METRICS = {"cosine", "atlas_vector_score"}
+
 
 class SearchRequest(BaseModel):
     """Search request with validation"""
@@ -41,6 +64,10 @@ class SearchRequest(BaseModel):
     top_k: Optional[int] = Field(default=5, ge=1, le=50)
     filters: Optional[Dict[str, Any]] = Field(default=None)
     include_metadata: bool = Field(default=True)
+    debug: bool = Field(
+        default=False,
+        description="Include per-result scoring breakdown (admins only in production)",
+    )
 
 
 class SearchResult(BaseModel):
@@ -52,6 +79,10 @@ class SearchResult(BaseModel):
     score: float
     source: str
     metadata: Optional[Dict[str, Any]] = None
+    truncated: bool = Field(
+        default=False, description="Content was clipped to the snippet size limit"
+    )
+    debug: Optional[Dict[str, Any]] = None
 
 
 class SearchResponse(BaseModel):
@@ -60,58 +91,128 @@ class SearchResponse(BaseModel):
     query: str
     results: List[SearchResult]
     total_results: int
+    candidate_count: int = Field(
+        description=(
+            "Distinct candidates retrieved before the result limit was applied. "
+            "Retrieval fetches a bounded candidate pool, so this is a lower bound "
+            "on all matching documents."
+        )
+    )
+    truncated: bool = Field(
+        default=False, description="More candidates were retrieved than returned"
+    )
     route_used: str
     processing_time_ms: float
     subscription_plan: str
     usage_info: Dict[str, Any]
     search_quality: Optional[str] = None
+    debug_info: Optional[Dict[str, Any]] = None
 
 
-async def record_search_usage(
-    user: User,
-    query: str,
-    results_count: int,
-    search_type: str,
-    billing_service: EnhancedBillingService,
-):
-    """Background task to record search usage"""
-    try:
-        await billing_service.record_usage(
-            user=user,
-            resource_type="api_calls",
-            quantity=1,
-            metadata={
-                "type": "search",
-                "query_length": len(query),
-                "results_returned": results_count,
-                "search_type": search_type,
-            },
+def search_debug_allowed(user: User) -> bool:
+    """Scoring breakdowns are for admins, or anyone outside production"""
+    return bool(getattr(user, "is_superuser", False)) or not config.is_production()
+
+
+def _filter_value(hit: Dict[str, Any], key: str) -> Any:
+    """Look up a filter field on a hit, where it usually lives under metadata"""
+    metadata = hit.get("metadata") or {}
+    field = key[len("metadata.") :] if key.startswith("metadata.") else key
+    return metadata.get(field, hit.get(key))
+
+
+def _score_breakdown(
+    hit: Dict[str, Any], rank: int, filters: Optional[Dict[str, Any]]
+) -> Dict[str, Any]:
+    """
+    Explain how a raw search hit was scored.
+
+    score_weights gives how much the lexical and vector scores count towards
+    final_score. adjustments lists each step that changed the score after
+    retrieval; the pipeline has no additive boosts, so it names the hybrid
+    re-rank and the zero score given to broader-retrieval hits.
+    """
+    metric = hit.get("metric", "unknown")
+    score = float(hit.get("score", 0.0))
+    lexical_score = hit.get("text_score")
+    if lexical_score is None and metric in LEXICAL_METRICS:
+        lexical_score = score
+
+    adjustments = []
+    if metric in VECTOR_METRICS and hit.get("text_score") is not None:
+        # Hybrid search keeps text candidates but ranks them by cosine alone
+        adjustments.append(
+            {
+                "type": "rerank",
+                "detail": "text candidate re-scored by vector similarity",
+                "from_score": float(hit["text_score"]),
+                "to_score": score,
+            }
+        )
+    elif metric == "fallback":
+        adjustments.append(
+            {
+                "type": "penalty",
+                "detail": "broader retrieval without a text or vector score",
+                "to_score": score,
+            }
         )
-    except Exception as e:
-        logger.error(f"Failed to record search usage: {e}")
+
+    return {
+        "rank": rank,
+        "final_score": score,
+        "metric": metric,
+        "lexical_score": lexical_score,
+        "vector_similarity": score if metric in VECTOR_METRICS else None,
+        "score_weights": {
+            "lexical": 1.0 if metric in LEXICAL_METRICS else 0.0,
+            "vector": 1.0 if metric in VECTOR_METRICS else 0.0,
+        },
+        "adjustments": adjustments,
+        "via_fallback": hit.get("via_fallback", False),
+        "filter_matches": {
+            key: _filter_value(hit, key) == value
+            for key, value in (filters or {}).items()
+        },
+    }
 
 
 @router.post("/", response_model=SearchResponse)
 async def search(
     request: SearchRequest,
-    background_tasks: BackgroundTasks,
-    current_user: User = Depends(check_search_quota),  # Quota check included
+    http_request: Request,
     _rate_limit: User = Depends(search_rate_limiter),  # Rate limiting
+    current_user: User = Depends(check_search_quota),  # Quota check
     knowledge_service: KnowledgeService = Depends(get_knowledge_service),
-    billing_service: EnhancedBillingService = Depends(get_billing_service),
+    session: AsyncSession = Depends(get_db_session),
 ) -> SearchResponse:
     """
     Perform semantic or hybrid search.
 
     Protected endpoint that:
     1. Requires authentication
-    2. Checks API call quota before processing
-    3. Applies rate limiting
-    4. Records usage for billing
-    5. Restricts features based on subscription plan
+    2. Applies rate limiting
+    3. Reserves API call quota atomically once the request is valid
+    4. Restricts features based on subscription plan
+
+    With debug=true, each result carries a scoring breakdown. Debug mode is
+    limited to admins when running in production.
+
+    If retrieval is overloaded, responds 503 with Retry-After and releases the
+    reserved API call quota.
     """
     start_time = time.time()
 
+    if request.debug and not search_debug_allowed(current_user):
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="Search debug mode is restricted to administrators",
+        )
+
+    # Only charge once rate limiting and request checks have passed
+    await check_search_quota.reserve(http_request, current_user, session)
+
+    succeeded = False
     try:
         if not knowledge_service:
             raise HTTPException(
@@ -120,13 +221,7 @@ async def search(
             )
 
         # Determine allowed search types based on subscription
-        allowed_routes = {
-            "free": ["exact", "auto"],
-            "pro": ["exact", "semantic", "hybrid", "auto"],
-            "enterprise": ["exact", "semantic", "hybrid", "auto"],
-        }
-
-        user_allowed_routes = allowed_routes.get(
+        user_allowed_routes = PLAN_SEARCH_ROUTES.get(
             current_user.subscription_plan, ["exact"]
         )
 
@@ -148,37 +243,33 @@ async def search(
         )
 
         # Process results
+        max_snippet_chars = config.search.max_snippet_chars
         results = []
-        for r in search_results.get("results", []):
+        for rank, r in enumerate(search_results.get("results", []), 1):
+            content = r.get("content", "")
             result = SearchResult(
                 document_id=r.get("document_id"),
                 title=r.get("title", "Document")[:100],
-                content=r.get("content", "")[:500],  # Limit content length
+                content=content[:max_snippet_chars],  # Limit content length
                 score=r.get("score", 0.0),
                 source=r.get("source", "unknown"),
+                truncated=len(content) > max_snippet_chars,
             )
 
             # Add metadata based on subscription
             if request.include_metadata and current_user.subscription_plan != "free":
                 result.metadata = r.get("metadata", {})
 
+            if request.debug:
+                result.debug = _score_breakdown(r, rank, request.filters)
+
             results.append(result)
 
         # Calculate processing time
         processing_time_ms = (time.time() - start_time) * 1000
 
-        # Get current usage
-        quota_info = await billing_service.check_user_quota(current_user, "api_calls")
-
-        # Record usage in background
-        background_tasks.add_task(
-            record_search_usage,
-            user=current_user,
-            query=request.query,
-            results_count=len(results),
-            search_type=search_results.get("route", route),
-            billing_service=billing_service,
-        )
+        # The API call was already recorded when the quota was reserved
+        quota_info = check_search_quota.reservation(http_request)
 
         # Determine search quality
         if results and results[0].score > 0.8:
@@ -188,21 +279,51 @@ async def search(
         else:
             search_quality = "needs_improvement"
 
-        return SearchResponse(
+        candidate_count = max(
+            len(results),
+            search_results.get("meta", {}).get("candidate_count", len(results)),
+        )
+
+        debug_info = None
+        if request.debug:
+            meta = search_results.get("meta", {})
+            debug_info = {
+                "requested_route": request.route,
+                "decided_route": meta.get("decided_route"),
+                "atlas_used": meta.get("atlas_used", False),
+                "fallback_applied": search_results.get("fallback_applied", False),
+                "fallback_attempts": meta.get("fallback_attempts", 0),
+                "filters": request.filters or {},
+                "search_quality": search_results.get("search_quality"),
+            }
+
+        search_response = SearchResponse(
             query=request.query,
             results=results,
             total_results=len(results),
+            candidate_count=candidate_count,
+            truncated=candidate_count > len(results),
             route_used=search_results.get("route", route),
             processing_time_ms=processing_time_ms,
             subscription_plan=current_user.subscription_plan,
             usage_info={
-                "api_calls_used": quota_info["current_usage"],
-                "api_calls_limit": quota_info["max_allowed"],
-                "api_calls_remaining": quota_info["remaining"],
+                "api_calls_used": quota_info.get("current_usage"),
+                "api_calls_limit": quota_info.get("max_allowed"),
+                "api_calls_remaining": quota_info.get("remaining"),
             },
             search_quality=search_quality,
+            debug_info=debug_info,
         )
+        succeeded = True
+        return search_response
 
+    except ServiceOverloadedError as e:
+        logger.warning(f"Search degraded for user {current_user.id}: {e}")
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail=f"Search is overloaded, please retry in {e.retry_after} seconds",
+            headers={"Retry-After": str(e.retry_after)},
+        )
     except HTTPException:
         raise
     except Exception as e:
@@ -210,16 +331,21 @@ async def search(
         raise HTTPException(
             status_code=status.HTTP_500_INTERNAL_SERVER_ERROR, detail="Search failed"
         )
+    finally:
+        # Don't charge the user for a request that produced nothing
+        await check_search_quota.settle(
+            http_request, current_user, session, succeeded, reason="search_failed"
+        )
 
 
 @router.post("/semantic", response_model=SearchResponse)
 async def semantic_search(
     request: SearchRequest,
-    background_tasks: BackgroundTasks,
-    current_user: User = Depends(check_search_quota),
+    http_request: Request,
     _rate_limit: User = Depends(search_rate_limiter),
+    current_user: User = Depends(check_search_quota),
     knowledge_service: KnowledgeService = Depends(get_knowledge_service),
-    billing_service: EnhancedBillingService = Depends(get_billing_service),
+    session: AsyncSession = Depends(get_db_session),
 ) -> SearchResponse:
     """
     Perform pure semantic/vector search.
@@ -239,11 +365,11 @@ async def semantic_search(
     request.route = "semantic"
     return await search(
         request,
-        background_tasks,
-        current_user,
-        _rate_limit,
-        knowledge_service,
-        billing_service,
+        http_request,
+        _rate_limit=_rate_limit,
+        current_user=current_user,
+        knowledge_service=knowledge_service,
+        session=session,
     )
 
 
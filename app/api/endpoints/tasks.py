@@ -0,0 +1,74 @@
+"""Background task endpoints with plan-based task quotas"""
+
+from __future__ import annotations
+
+import logging
+from typing import Optional
+from uuid import uuid4
+
+from fastapi import APIRouter, Depends, HTTPException, status
+from pydantic import BaseModel, Field
+from sqlalchemy.ext.asyncio import AsyncSession
+
+from app.core.auth_dependencies import get_current_active_user
+from app.database.postgres_models import User
+from app.dependencies import get_background_task_service, get_db_session
+from app.services.background_tasks import BackgroundTaskService, TaskQuotaExceededError
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter(prefix="/tasks", tags=["tasks"])
+
+
+class TaskSubmitRequest(BaseModel):
+    """Long-running task to run in the background"""
+
+    task_type: str = Field(..., pattern="^(research|data_analysis)$")
+    description: str = Field(..., min_length=1, max_length=2000)
+    session_id: Optional[str] = None
+
+
+class TaskSubmitResponse(BaseModel):
+    task_id: str
+    task_type: str
+    session_id: str
+
+
+@router.post(
+    "/", response_model=TaskSubmitResponse, status_code=status.HTTP_202_ACCEPTED
+)
+async def submit_task(
+    request: TaskSubmitRequest,
+    current_user: User = Depends(get_current_active_user),
+    background_service: BackgroundTaskService = Depends(get_background_task_service),
+    session: AsyncSession = Depends(get_db_session),
+) -> TaskSubmitResponse:
+    """
+    Queue a research or data analysis task.
+
+    Each task is charged to the plan's background task quota; once it is
+    used up the request is rejected with 429 until the next billing period.
+    """
+    session_id = request.session_id or str(uuid4())
+    submit = (
+        background_service.submit_research_task
+        if request.task_type == "research"
+        else background_service.submit_data_analysis_task
+    )
+    try:
+        task_id = await submit(current_user, request.description, session_id, session)
+    except TaskQuotaExceededError as e:
+        raise HTTPException(
+            status_code=status.HTTP_429_TOO_MANY_REQUESTS,
+            detail={
+                "error": str(e),
+                "current_usage": e.current_usage,
+                "max_allowed": e.max_allowed,
+                "upgrade_url": "/billing/plans",
+            },
+        )
+
+    return TaskSubmitResponse(
+        task_id=task_id, task_type=request.task_type, session_id=session_id
+    )
+
@@ -12,12 +12,16 @@ import os
 import time
 import importlib
 from contextlib import asynccontextmanager
+from datetime import datetime
 from typing import Dict, Any, List, Optional
+from uuid import UUID
 
-from fastapi import FastAPI, HTTPException, Depends
+from fastapi import FastAPI, HTTPException, Depends, Query
+from fastapi.exceptions import RequestValidationError
 from fastapi.middleware.cors import CORSMiddleware
 from fastapi.responses import JSONResponse
 from pydantic import BaseModel
+from starlette.exceptions import HTTPException as StarletteHTTPException
 
 # Enhanced database connections
 from app.database.mongo_connection import (
@@ -26,8 +30,18 @@ from app.database.mongo_connection import (
     enhanced_mongo_manager,
 )
 
-from app.core.auth_dependencies import get_admin_user
+from app.core.auth_dependencies import get_admin_user, get_db_session
+from app.core.http_caching import ConditionalGetMiddleware
+from app.core.pagination import PagedResponse
+from app.core.request_id import (
+    REQUEST_ID_HEADER,
+    RequestIDMiddleware,
+    get_request_id,
+    http_exception_handler,
+    validation_exception_handler,
+)
 from app.database.postgres_models import User
+from sqlalchemy.ext.asyncio import AsyncSession
 
 # Configure logging early
 logging.basicConfig(
@@ -67,7 +81,8 @@ async def _safe_initialize_postgresql() -> bool:
             logger.error("❌ PostgreSQL connection test failed")
             return False
 
-        # Create tables if they don't exist
+        # Create tables if they don't exist; there are no migrations, so
+        # existing tables are never altered here
         logger.info("📋 Creating PostgreSQL tables...")
         async with postgres_manager.engine.begin() as conn:
             await conn.run_sync(DatabaseBase.metadata.create_all)
@@ -95,6 +110,9 @@ async def lifespan(app: FastAPI):
     from app.dependencies import get_embedding_service, get_generation_service
     from app.config import config
 
+    # --- Refuse to serve production traffic with default credentials ---
+    config.enforce_security_posture()
+
     # --- Connect to Databases and Caches on STARTUP ---
     await postgres_manager.initialize()
     await init_enhanced_mongo()
@@ -370,6 +388,25 @@ app.add_middleware(
 )
 
 
+def _plans_last_modified(path: str) -> datetime:
+    """Last-Modified for the plans listing, taken from the plan definitions"""
+    from app.dependencies import get_billing_service
+
+    return get_billing_service().plans_last_modified()
+
+
+# ETag/Last-Modified support for effectively-static read-only routes
+app.add_middleware(
+    ConditionalGetMiddleware,
+    paths=["/billing/plans"],
+    max_age=300,
+    last_modified=_plans_last_modified,
+)
+
+# Registered last so it wraps everything and every response carries the ID
+app.add_middleware(RequestIDMiddleware)
+
+
 # -----------------------------
 # Enhanced Response Models
 # -----------------------------
@@ -751,6 +788,66 @@ async def get_seeding_status(admin_user: User = Depends(get_admin_user)):
         )
 
 
+@app.get(
+    "/admin/audit-logs",
+    response_model=PagedResponse[Dict[str, Any]],
+    tags=["admin"],
+)
+async def get_audit_logs(
+    user_id: Optional[UUID] = Query(default=None),
+    action: Optional[str] = Query(default=None),
+    resource_type: Optional[str] = Query(default=None),
+    start_time: Optional[datetime] = Query(default=None),
+    end_time: Optional[datetime] = Query(default=None),
+    meta_key: Optional[str] = Query(
+        default=None,
+        max_length=200,
+        description="Dotted path into new_values/old_values, e.g. context.access_reason",
+    ),
+    meta_value: Optional[str] = Query(default=None, max_length=500),
+    purpose: Optional[str] = Query(
+        default=None,
+        max_length=200,
+        description="Access purpose recorded at new_values.context.access_reason",
+    ),
+    limit: int = Query(default=50, ge=1, le=500),
+    offset: int = Query(default=0, ge=0),
+    admin_user: User = Depends(get_admin_user),
+    session: AsyncSession = Depends(get_db_session),
+):
+    """Query the audit trail with filters and pagination (admin only)"""
+    if start_time and end_time and start_time > end_time:
+        raise HTTPException(
+            status_code=400, detail="start_time must not be after end_time"
+        )
+    if meta_value is not None and not meta_key:
+        raise HTTPException(status_code=400, detail="meta_value requires meta_key")
+
+    try:
+        from app.dependencies import get_user_service
+
+        result = await get_user_service().get_audit_logs(
+            session,
+            requested_by=admin_user,
+            user_id=user_id,
+            action=action,
+            resource_type=resource_type,
+            start_time=start_time,
+            end_time=end_time,
+            meta_key=meta_key,
+            meta_value=meta_value,
+            purpose=purpose,
+            limit=limit,
+            offset=offset,
+        )
+        return PagedResponse[Dict[str, Any]].build(
+            result["items"], result["total"], limit, offset
+        )
+    except Exception as e:
+        logger.error(f"Failed to query audit logs: {e}")
+        raise HTTPException(status_code=500, detail="Failed to query audit logs")
+
+
 @app.post("/admin/cleanup", tags=["admin"])
 async def force_cleanup(admin_user: User = Depends(get_admin_user)):
     """Force cleanup of AI services (admin only)"""
@@ -946,6 +1043,7 @@ routers_to_load = [
     ("app.api.endpoints.search", "router"),  # Enhanced search with Atlas Vector Search
     ("app.api.endpoints.chat", "router"),  # Enhanced chat with real LLM generation
     ("app.api.endpoints.billing", "router"),
+    ("app.api.endpoints.tasks", "router"),  # Quota-checked background tasks
 ]
 
 for module_path, router_attr in routers_to_load:
@@ -959,6 +1057,10 @@ logger.info("✅ Router loading completed")
 # -----------------------------
 
 
+app.add_exception_handler(StarletteHTTPException, http_exception_handler)
+app.add_exception_handler(RequestValidationError, validation_exception_handler)
+
+
 @app.exception_handler(Exception)
 async def global_exception_handler(request, exc):
     """Global exception handler with enhanced logging"""
@@ -979,13 +1081,17 @@ async def global_exception_handler(request, exc):
     except Exception:
         pass  # Telemetry is optional
 
+    # Unhandled errors bypass the middleware, so set the header here too
+    request_id = get_request_id(request)
     return JSONResponse(
         status_code=500,
         content={
             "error": "Internal server error",
             "message": "An unexpected error occurred. Please check the logs.",
             "timestamp": time.strftime("%Y-%m-%dT%H:%M:%S"),
+            "request_id": request_id,
         },
+        headers={REQUEST_ID_HEADER: request_id},
     )
 
 
@@ -1031,6 +1137,70 @@ async def root():
             "search": "/search",
             "docs": "/docs",
             "admin": "/admin/seed-status",
+            "capabilities": "/capabilities",
+        },
+    }
+
+
+@app.get("/capabilities", tags=["root"])
+async def capabilities():
+    """Supported features, versions and limits, independent of service health"""
+    from app.api.endpoints.chat import ChatRequest, chat_rate_limiter
+    from app.api.endpoints.search import (
+        PLAN_SEARCH_ROUTES,
+        SearchRequest,
+        search_rate_limiter,
+    )
+    from app.config import config
+    from app.dependencies import get_billing_service
+
+    def constraint(model, field: str, name: str):
+        """Read a validation bound (max_length, le, ...) off a request model"""
+        for rule in model.model_fields[field].metadata:
+            if hasattr(rule, name):
+                return getattr(rule, name)
+        return None
+
+    plans = get_billing_service().get_available_plans()["plans"]
+    search_routes = sorted(
+        {route for routes in PLAN_SEARCH_ROUTES.values() for route in routes}
+    )
+
+    return {
+        "service": "chatbot-api",
+        "version": app.version,
+        "features": {
+            "chat": {"rag": True, "sources": True},
+            "search": {
+                "routes": search_routes,
+                "routes_by_plan": PLAN_SEARCH_ROUTES,
+            },
+            "billing": {
+                "plans": [plan["id"] for plan in plans],
+                "quota_overrides": True,
+            },
+            "http": {"conditional_get": ["/billing/plans"], "request_id": True},
+        },
+        "models": {
+            "embedding": config.embedding.model_name,
+            "generation": config.generation.model_name,
+        },
+        "limits": {
+            "chat_message_max_chars": constraint(ChatRequest, "message", "max_length"),
+            "search_query_max_chars": constraint(SearchRequest, "query", "max_length"),
+            "search_top_k_max": constraint(SearchRequest, "top_k", "le"),
+            "search_snippet_max_chars": config.search.max_snippet_chars,
+            "rate_limits": {
+                "chat": {
+                    "calls": chat_rate_limiter.calls,
+                    "period_seconds": chat_rate_limiter.period,
+                },
+                "search": {
+                    "calls": search_rate_limiter.calls,
+                    "period_seconds": search_rate_limiter.period,
+                },
+            },
+            "plan_quotas": {plan["id"]: plan["limits"] for plan in plans},
         },
     }
 
@@ -1,4 +1,5 @@
 import os
+import logging
 from typing import Optional
 from dataclasses import dataclass
 from dotenv import load_dotenv
@@ -6,6 +7,21 @@ import secrets
 
 load_dotenv()
 
+logger = logging.getLogger(__name__)
+
+# Placeholder credentials from defaults and .env.example that must not reach production
+WEAK_SECRET_KEYS = {"", "secret", "changeme", "your-secret-key-here"}
+DEFAULT_DB_PASSWORDS = {
+    "",
+    "password",
+    "postgres",
+    "example",
+    "secure_password",
+    "your_postgres_password",
+    "your_mongo_password",
+}
+MIN_SECRET_KEY_LENGTH = 32
+
 
 @dataclass
 class EmbeddingConfig:
@@ -119,6 +135,7 @@ class SearchConfig:
     rag_top_k: int = int(os.getenv("RAG_TOP_K", "10"))
     rag_max_snippets: int = int(os.getenv("RAG_MAX_SNIPPETS", "5"))
     rag_diversity_threshold: float = float(os.getenv("RAG_DIVERSITY_THRESHOLD", "0.85"))
+    max_snippet_chars: int = int(os.getenv("SEARCH_MAX_SNIPPET_CHARS", "500"))
 
 
 @dataclass
@@ -180,6 +197,7 @@ class PostgreSQLConfig:
     pool_timeout: int = 30
     pool_recycle: int = 3600
     secret_key: str = os.getenv("SECRET_KEY", secrets.token_urlsafe(32))
+    secret_key_configured: bool = bool(os.getenv("SECRET_KEY"))
     jwt_algorithm: str = "HS256"
     jwt_expire_minutes: int = 1440
 
@@ -250,7 +268,42 @@ class ApplicationConfig:
     search: SearchConfig
 
     log_level: str = os.getenv("LOG_LEVEL", "INFO")
+    environment: str = os.getenv("ENVIRONMENT", "development")
+    hipaa_mode: bool = os.getenv("HIPAA_MODE", "0") == "1"
+    allow_insecure_production: bool = (
+        os.getenv("ALLOW_INSECURE_PRODUCTION", "0") == "1"
+    )
     api_rate_limit: int = int(os.getenv("API_RATE_LIMIT", "100"))
+    # How each resource's quota resets: periodic (default), standing (never
+    # resets) or rollover:<cap> (unused units carry into the next period)
+    quota_reset_policies: str = os.getenv(
+        "QUOTA_RESET_POLICIES", "storage_mb=standing"
+    )
+    # Paid subscriptions with no usage for this many days are flagged, then
+    # downgraded one tier after the grace window (never cancelled)
+    inactive_downgrade_enabled: bool = (
+        os.getenv("INACTIVE_DOWNGRADE_ENABLED", "0") == "1"
+    )
+    inactive_downgrade_after_days: int = int(
+        os.getenv("INACTIVE_DOWNGRADE_AFTER_DAYS", "90")
+    )
+    inactive_downgrade_grace_days: int = int(
+        os.getenv("INACTIVE_DOWNGRADE_GRACE_DAYS", "14")
+    )
+    # Flag accounts whose usage rate this period exceeds their historical
+    # daily rate by the multiplier; optionally freeze them at current usage
+    usage_anomaly_enabled: bool = os.getenv("USAGE_ANOMALY_ENABLED", "0") == "1"
+    usage_anomaly_multiplier: float = float(
+        os.getenv("USAGE_ANOMALY_MULTIPLIER", "3.0")
+    )
+    usage_anomaly_baseline_periods: int = int(
+        os.getenv("USAGE_ANOMALY_BASELINE_PERIODS", "3")
+    )
+    usage_anomaly_min_usage: int = int(os.getenv("USAGE_ANOMALY_MIN_USAGE", "50"))
+    usage_anomaly_throttle: bool = os.getenv("USAGE_ANOMALY_THROTTLE", "0") == "1"
+    usage_anomaly_throttle_hours: int = int(
+        os.getenv("USAGE_ANOMALY_THROTTLE_HOURS", "24")
+    )
     max_chat_history: int = 50
 
     enable_caching: bool = True
@@ -280,6 +333,10 @@ class ApplicationConfig:
     enable_auto_background: bool = True
 
     auto_background_threshold_seconds: int = 8
+    # Worker threads per task type; "default" handles unclassified tasks
+    background_worker_groups: str = os.getenv(
+        "BACKGROUND_WORKER_GROUPS", "research=1,data_analysis=1,default=1"
+    )
     timeout_check_interval_seconds: float = 1.0
     min_confidence_for_auto_background: float = 0.6
     min_confidence_for_timeout: float = 0.4
@@ -398,6 +455,75 @@ class ApplicationConfig:
             },
         }
 
+    def is_production(self) -> bool:
+        return self.environment.lower() in ("production", "prod")
+
+    def validate_security_posture(self) -> dict:
+        issues = []
+        warnings = []
+
+        secret_key = self.postgresql.secret_key
+        if not self.postgresql.secret_key_configured:
+            issues.append(
+                "SECRET_KEY is not set - a random per-process key invalidates "
+                "tokens on restart and across workers"
+            )
+        elif secret_key in WEAK_SECRET_KEYS or len(secret_key) < MIN_SECRET_KEY_LENGTH:
+            issues.append(
+                f"SECRET_KEY is weak - use at least {MIN_SECRET_KEY_LENGTH} random characters"
+            )
+
+        if self.postgresql.password in DEFAULT_DB_PASSWORDS:
+            issues.append("POSTGRES_PASSWORD is a default/placeholder value")
+
+        # Atlas credentials live in the URI, so the local password only matters
+        # without one
+        if (
+            not self.atlas_search.atlas_uri
+            and self.mongo.password in DEFAULT_DB_PASSWORDS
+        ):
+            issues.append("MONGO_PASSWORD is a default/placeholder value")
+
+        if not self.redis.password:
+            warnings.append("REDIS_PASSWORD is not set")
+
+        if not self.hipaa_mode:
+            issues.append("HIPAA_MODE is off")
+
+        return {"valid": len(issues) == 0, "issues": issues, "warnings": warnings}
+
+    def enforce_security_posture(self) -> dict:
+        """
+        Check the security posture at startup.
+
+        Outside production problems are logged as warnings. In production any
+        issue aborts startup unless ALLOW_INSECURE_PRODUCTION=1 is set.
+        """
+        posture = self.validate_security_posture()
+        if not self.is_production():
+            for problem in posture["issues"] + posture["warnings"]:
+                logger.warning(f"Security posture ({self.environment}): {problem}")
+            return posture
+
+        for warning in posture["warnings"]:
+            logger.warning(f"Security posture: {warning}")
+        if posture["valid"]:
+            return posture
+
+        for issue in posture["issues"]:
+            logger.error(f"Security posture: {issue}")
+        if self.allow_insecure_production:
+            logger.warning(
+                "Starting in production despite security posture issues "
+                "(ALLOW_INSECURE_PRODUCTION=1)"
+            )
+            return posture
+
+        raise RuntimeError(
+            "Refusing to start in production with an insecure configuration: "
+            + "; ".join(posture["issues"])
+        )
+
     def validate_seeding_configuration(self) -> dict:
         issues = []
         warnings = []
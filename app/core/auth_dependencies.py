@@ -1,10 +1,10 @@
 """Enhanced authentication dependencies with role-based access control - FIXED"""
 
-from typing import Optional
-from fastapi import Depends, HTTPException, status
+from typing import Any, Dict, Optional
+from fastapi import Depends, HTTPException, Request, status
 from fastapi.security import HTTPBearer, HTTPAuthorizationCredentials
 from sqlalchemy.ext.asyncio import AsyncSession
-from uuid import UUID
+from uuid import UUID, uuid4
 import logging
 
 # FIXED: Import the GETTER functions, not the service instances
@@ -68,12 +68,18 @@ class QuotaChecker:
 
     async def __call__(
         self,
+        request: Request,
         current_user: User = Depends(get_current_active_user),
         session: AsyncSession = Depends(
             get_db_session
         ),  # FIXED: Get session dependency
     ) -> User:
-        """Check if user has quota for the requested resource."""
+        """Check if user has quota for the requested resource.
+
+        This is read-only: FastAPI resolves dependencies before it rejects an
+        invalid body, so anything written here would be charged on a 422.
+        Endpoints call reserve() once the request is known to be valid.
+        """
         # Get the billing service inside the call
         billing_service = get_billing_service()
         try:
@@ -97,6 +103,102 @@ class QuotaChecker:
             # Allow request on error (fail open)
         return current_user
 
+    async def reserve(
+        self,
+        request: Request,
+        current_user: User,
+        session: AsyncSession,
+    ) -> None:
+        """Record one unit of usage atomically with the quota check.
+
+        The result is available to the endpoint through reservation().
+        """
+        billing_service = get_billing_service()
+        try:
+            quota_info = await billing_service.reserve_usage(
+                current_user,
+                self.resource_type,
+                session,
+                extra_data={"endpoint": request.url.path},
+                operation_id=str(uuid4()),
+            )
+            reservations = getattr(request.state, "quota_reservations", None) or {}
+            reservations[self.resource_type] = quota_info
+            request.state.quota_reservations = reservations
+            if not quota_info.get("has_quota"):
+                raise HTTPException(
+                    status_code=status.HTTP_429_TOO_MANY_REQUESTS,
+                    detail=f"Quota exceeded for resource: {self.resource_type}",
+                )
+        except HTTPException:
+            raise
+        except Exception as e:
+            logger.error(
+                f"Quota reservation failed for user {current_user.id}, "
+                f"allowing request: {e}"
+            )
+
+    def reservation(self, request: Request) -> Dict[str, Any]:
+        """Usage reserved for this request by reserve(), or {} if there is none"""
+        reservations = getattr(request.state, "quota_reservations", None) or {}
+        return reservations.get(self.resource_type) or {}
+
+    async def release(
+        self,
+        request: Request,
+        current_user: User,
+        session: AsyncSession,
+        reason: Optional[str] = None,
+    ) -> None:
+        """Credit back the usage reserved for this request after a failure."""
+        operation_id = self.reservation(request).get("operation_id")
+        if not operation_id:
+            return
+
+        billing_service = get_billing_service()
+        try:
+            await billing_service.release_usage(
+                current_user, self.resource_type, operation_id, session, reason=reason
+            )
+        except Exception as e:
+            logger.error(
+                f"Failed to release {self.resource_type} for user {current_user.id}: {e}"
+            )
+
+    async def settle(
+        self,
+        request: Request,
+        current_user: User,
+        session: AsyncSession,
+        succeeded: bool,
+        reason: Optional[str] = None,
+    ) -> None:
+        """Finalize usage for this request once the endpoint has finished.
+
+        Any exit other than success credits the reservation back. A successful
+        request that has no reservation (the reservation failed open) is
+        recorded after the fact so it isn't served for free.
+        """
+        if not succeeded:
+            await self.release(request, current_user, session, reason=reason)
+            return
+
+        if self.reservation(request):
+            return
+
+        billing_service = get_billing_service()
+        try:
+            await billing_service.record_usage(
+                current_user,
+                self.resource_type,
+                session,
+                extra_data={"endpoint": request.url.path, "unreserved": True},
+            )
+        except Exception as e:
+            logger.error(
+                f"Failed to record {self.resource_type} for user {current_user.id}: {e}"
+            )
+
 
 # Pre-configured quota checkers for common resources
 check_message_quota = QuotaChecker("messages")
@@ -0,0 +1,117 @@
+"""HTTP caching support for read-only endpoints"""
+
+import hashlib
+import logging
+from datetime import datetime, timezone
+from email.utils import format_datetime, parsedate_to_datetime
+from typing import Callable, Iterable, Optional
+
+from starlette.middleware.base import BaseHTTPMiddleware
+from starlette.requests import Request
+from starlette.responses import Response
+
+logger = logging.getLogger(__name__)
+
+# Headers that describe a body, which a 304 must not carry
+BODY_HEADERS = {"content-length", "content-type"}
+
+
+class ConditionalGetMiddleware(BaseHTTPMiddleware):
+    """
+    Add ETag/Last-Modified validators to designated read-only GET routes.
+
+    Requests carrying a matching If-None-Match (or, failing that, an
+    If-Modified-Since not older than the current representation) are
+    short-circuited with 304 Not Modified and an empty body. Last-Modified
+    comes from last_modified(path), i.e. from the data behind the route, so
+    every worker reports the same date; without it only ETag is sent.
+    """
+
+    def __init__(
+        self,
+        app,
+        paths: Iterable[str],
+        max_age: int = 300,
+        last_modified: Optional[Callable[[str], Optional[datetime]]] = None,
+    ):
+        super().__init__(app)
+        self.paths = set(paths)
+        self.max_age = max_age
+        self.last_modified = last_modified
+
+    async def dispatch(self, request: Request, call_next) -> Response:
+        if request.method not in ("GET", "HEAD") or request.url.path not in self.paths:
+            return await call_next(request)
+
+        response = await call_next(request)
+        if response.status_code != 200:
+            return response
+
+        body = b"".join([chunk async for chunk in response.body_iterator])
+        etag = f'"{hashlib.sha256(body).hexdigest()[:32]}"'
+        last_modified = self._last_modified(request.url.path)
+
+        cache_headers = {
+            "ETag": etag,
+            "Cache-Control": f"public, max-age={self.max_age}",
+        }
+        if last_modified is not None:
+            cache_headers["Last-Modified"] = format_datetime(last_modified, usegmt=True)
+
+        # Keep Vary, CORS and other inner headers; a 304 only loses the ones
+        # describing the body, and a 200 gets its length recomputed
+        not_modified = self._is_not_modified(request, etag, last_modified)
+        dropped = BODY_HEADERS if not_modified else {"content-length"}
+        headers = {
+            key: value
+            for key, value in response.headers.items()
+            if key.lower() not in dropped
+        }
+        headers.update(cache_headers)
+
+        if not_modified:
+            return Response(status_code=304, headers=headers)
+        return Response(content=body, status_code=200, headers=headers)
+
+    def _last_modified(self, path: str) -> Optional[datetime]:
+        """Return when the data behind path last changed, if known"""
+        if self.last_modified is None:
+            return None
+        modified = self.last_modified(path)
+        if modified is None:
+            return None
+        if modified.tzinfo is None:
+            modified = modified.replace(tzinfo=timezone.utc)
+        # HTTP dates have second precision
+        return modified.astimezone(timezone.utc).replace(microsecond=0)
+
+    @staticmethod
+    def _is_not_modified(
+        request: Request, etag: str, last_modified: Optional[datetime]
+    ) -> bool:
+        """Evaluate conditional request headers (If-None-Match takes precedence)"""
+        if_none_match = request.headers.get("if-none-match")
+        if if_none_match is not None:
+            candidates = [tag.strip() for tag in if_none_match.split(",")]
+            return "*" in candidates or any(
+                tag.removeprefix("W/") == etag for tag in candidates
+            )
+
+        if_modified_since = request.headers.get("if-modified-since")
+        if if_modified_since and last_modified is not None:
+            since = _parse_http_date(if_modified_since)
+            return since is not None and last_modified <= since
+
+        return False
+
+
+def _parse_http_date(value: str) -> Optional[datetime]:
+    """Parse an HTTP date header, returning None when it is malformed"""
+    try:
+        parsed = parsedate_to_datetime(value)
+    except (TypeError, ValueError):
+        logger.debug(f"Ignoring malformed HTTP date: {value!r}")
+        return None
+    if parsed.tzinfo is None:
+        parsed = parsed.replace(tzinfo=timezone.utc)
+    return parsed
@@ -0,0 +1,18 @@
+"""Overload signalling between AI services and the API layer"""
+
+from typing import Optional
+
+
+class ServiceOverloadedError(RuntimeError):
+    """
+    Raised when a downstream AI service is at capacity.
+
+    Unlike ordinary failures this is expected under load, so callers
+    should degrade gracefully and advise the client when to retry rather
+    than falling back silently or failing hard.
+    """
+
+    def __init__(self, service: str, retry_after: int, detail: Optional[str] = None):
+        self.service = service
+        self.retry_after = retry_after
+        super().__init__(detail or f"{service} service is overloaded")
@@ -0,0 +1,45 @@
+"""Shared pagination envelope for list endpoints"""
+
+from typing import Any, Generic, List, Optional, Sequence, TypeVar
+
+from pydantic import BaseModel, Field
+
+T = TypeVar("T")
+
+
+class PagedResponse(BaseModel, Generic[T]):
+    """Uniform page of results returned by every list endpoint"""
+
+    items: List[T]
+    total: int = Field(..., ge=0, description="Matching items across all pages")
+    limit: int = Field(..., ge=1)
+    offset: int = Field(default=0, ge=0)
+    next_cursor: Optional[str] = Field(
+        default=None, description="Opaque cursor for the next page, if any"
+    )
+    has_more: bool = False
+
+    @classmethod
+    def build(
+        cls,
+        items: Sequence[T],
+        total: int,
+        limit: int,
+        offset: int = 0,
+        **extra: Any,
+    ) -> "PagedResponse[T]":
+        """
+        Build an offset-paginated page, deriving has_more and next_cursor.
+
+        Extra keyword arguments populate fields added by subclasses.
+        """
+        has_more = offset + len(items) < total
+        return cls(
+            items=list(items),
+            total=total,
+            limit=limit,
+            offset=offset,
+            next_cursor=str(offset + len(items)) if has_more else None,
+            has_more=has_more,
+            **extra,
+        )
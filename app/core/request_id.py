@@ -0,0 +1,75 @@
+"""Request ID propagation for log correlation"""
+
+import re
+import uuid
+from typing import Dict, Optional
+
+from fastapi.encoders import jsonable_encoder
+from fastapi.exceptions import RequestValidationError
+from fastapi.responses import JSONResponse
+from starlette.exceptions import HTTPException
+from starlette.middleware.base import BaseHTTPMiddleware
+from starlette.requests import Request
+from starlette.responses import Response
+
+REQUEST_ID_HEADER = "X-Request-ID"
+
+# Accept caller-supplied IDs only if they are short and header/log safe
+_VALID_REQUEST_ID = re.compile(r"^[A-Za-z0-9._:-]{1,128}$")
+
+
+def get_request_id(request: Request) -> str:
+    """Return the request ID resolved by RequestIDMiddleware (empty if absent)"""
+    return getattr(request.state, "request_id", "")
+
+
+class RequestIDMiddleware(BaseHTTPMiddleware):
+    """
+    Resolve a request ID for every request and echo it in X-Request-ID.
+
+    An incoming X-Request-ID is reused so callers can correlate their own
+    logs; otherwise a new one is generated. Handlers read it from
+    request.state.request_id.
+    """
+
+    async def dispatch(self, request: Request, call_next) -> Response:
+        incoming = request.headers.get(REQUEST_ID_HEADER, "")
+        request_id = (
+            incoming if _VALID_REQUEST_ID.match(incoming) else uuid.uuid4().hex
+        )
+        request.state.request_id = request_id
+
+        response = await call_next(request)
+        response.headers[REQUEST_ID_HEADER] = request_id
+        return response
+
+
+def _error_response(
+    request: Request,
+    status_code: int,
+    content: dict,
+    headers: Optional[Dict[str, str]] = None,
+) -> JSONResponse:
+    request_id = get_request_id(request)
+    return JSONResponse(
+        status_code=status_code,
+        content={**content, "request_id": request_id},
+        headers={**(headers or {}), REQUEST_ID_HEADER: request_id},
+    )
+
+
+async def http_exception_handler(request: Request, exc: HTTPException) -> JSONResponse:
+    """HTTPException handler that adds the request ID to the error body"""
+    return _error_response(
+        request,
+        exc.status_code,
+        {"detail": exc.detail},
+        headers=getattr(exc, "headers", None),
+    )
+
+
+async def validation_exception_handler(
+    request: Request, exc: RequestValidationError
+) -> JSONResponse:
+    """Validation error handler that adds the request ID to the error body"""
+    return _error_response(request, 422, {"detail": jsonable_encoder(exc.errors())})
@@ -61,6 +61,7 @@ try:
         Organization,
         Subscription,
         UsageRecord,
+        QuotaOverride,
         AuditLog,
         FeatureFlag,
         SystemSetting,
@@ -178,6 +179,7 @@ __all__ = [
     "Organization",
     "Subscription",
     "UsageRecord",
+    "QuotaOverride",
     "AuditLog",
     "FeatureFlag",
     "SystemSetting",
@@ -112,6 +112,9 @@ class Subscription(DatabaseBase, TimestampMixin):
     auto_renew: Mapped[bool] = mapped_column(Boolean, default=True)
 
     limits: Mapped[Optional[dict]] = mapped_column(JSONB, default=dict)
+    # Hash of the plan terms the limits and price were copied from; None for
+    # subscriptions created before versions were recorded
+    plan_version: Mapped[Optional[str]] = mapped_column(String(64))
 
     user: Mapped["User"] = relationship("User", back_populates="subscriptions")
 
@@ -153,6 +156,43 @@ class UsageRecord(DatabaseBase, TimestampMixin):
     )
 
 
+class QuotaOverride(DatabaseBase, TimestampMixin):
+    """
+    Temporary quota limit that supersedes the plan default.
+
+    kind is "grant" for admin-granted limits and "throttle" for limits set
+    after a usage anomaly; an active throttle wins over any grant.
+    """
+
+    __tablename__ = "quota_overrides"
+
+    id: Mapped[uuid.UUID] = mapped_column(
+        PostgresUUID(as_uuid=True), primary_key=True, default=uuid.uuid4
+    )
+    user_id: Mapped[uuid.UUID] = mapped_column(
+        PostgresUUID(as_uuid=True), ForeignKey("users.id"), nullable=False
+    )
+
+    resource_type: Mapped[str] = mapped_column(String(50), nullable=False)
+    max_allowed: Mapped[int] = mapped_column(Integer, nullable=False)
+    kind: Mapped[str] = mapped_column(
+        String(20), nullable=False, default="grant", server_default="grant"
+    )
+    reason: Mapped[str] = mapped_column(Text, nullable=False)
+
+    granted_by: Mapped[Optional[uuid.UUID]] = mapped_column(
+        PostgresUUID(as_uuid=True), ForeignKey("users.id")
+    )
+    expires_at: Mapped[datetime] = mapped_column(
+        DateTime(timezone=True), nullable=False
+    )
+    revoked_at: Mapped[Optional[datetime]] = mapped_column(DateTime(timezone=True))
+
+    __table_args__ = (
+        Index("idx_quota_override_user_resource", "user_id", "resource_type"),
+    )
+
+
 class AuditLog(DatabaseBase, TimestampMixin):
     """Audit trail for compliance and security monitoring"""
 
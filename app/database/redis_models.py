@@ -407,6 +407,7 @@ class BillingCacheModel(RedisBaseModel):
                 "limits": subscription.limits,
                 "amount_cents": subscription.amount_cents,
                 "currency": subscription.currency,
+                "plan_version": subscription.plan_version,
             }
 
             return self.redis.setex(key, ttl, self._serialize(sub_data))
@@ -18,6 +18,7 @@ if TYPE_CHECKING:
     from app.services.auth_service import AuthService
     from app.services.user_service import UserService
     from app.services.multi_db_service import MultiDatabaseService
+    from app.services.background_tasks import BackgroundTaskService
     from app.database.scylla_connection import ScyllaDBConnection
 
 embedding_service: Optional["EmbeddingService"] = None
@@ -28,6 +29,7 @@ billing_service: Optional["EnhancedBillingService"] = None
 auth_service: Optional["AuthService"] = None
 user_service: Optional["UserService"] = None
 multi_db_service: Optional["MultiDatabaseService"] = None
+background_task_service: Optional["BackgroundTaskService"] = None
 scylla_manager: Optional["ScyllaDBConnection"] = None
 
 postgres_manager: Optional[AsyncSession] = None
@@ -121,6 +123,23 @@ def get_billing_service() -> "EnhancedBillingService":
     return billing_service
 
 
+def get_background_task_service() -> "BackgroundTaskService":
+    """Get or create background task service instance"""
+    global background_task_service
+    if background_task_service is None:
+        try:
+            from app.services.background_tasks import BackgroundTaskService
+
+            background_task_service = BackgroundTaskService(
+                billing_service=get_billing_service()
+            )
+            logger.info("Created BackgroundTaskService instance")
+        except Exception as e:
+            logger.error(f"Failed to create BackgroundTaskService: {e}")
+            raise
+    return background_task_service
+
+
 def get_auth_service() -> "AuthService":
     """Get or create auth service instance"""
     global auth_service
@@ -312,6 +331,13 @@ class MockBillingService:
     async def record_usage(self, user, resource_type: str, session=None, quantity=1, extra_data=None):
         return True
 
+    async def reserve_usage(self, user, resource_type: str, session=None, quantity=1, extra_data=None, operation_id=None):
+        quota_info = await self.check_user_quota(user, resource_type, session)
+        return {**quota_info, "reserved": True, "operation_id": operation_id}
+
+    async def release_usage(self, user, resource_type: str, operation_id: str, session=None, reason=None):
+        return {"released": True, "already_released": False, "quantity_released": 1}
+
     async def get_usage_summary(self, user, session=None):
         plan_type = getattr(user, "subscription_plan", "free")
         return {
@@ -356,7 +382,11 @@ class MockBillingService:
         }
 
     def get_available_plans(self):
-        return {"plans": list(self._plan_definitions.values()), "currency": "USD"}
+        plans = [
+            {"id": plan_id, **plan_data}
+            for plan_id, plan_data in self._plan_definitions.items()
+        ]
+        return {"plans": plans, "currency": "USD"}
 
 
 async def get_postgres_session() -> AsyncGenerator[AsyncSession, None]:
@@ -374,6 +404,7 @@ def reset_services():
     global embedding_service, generation_service, knowledge_service
     global chatbot_service, billing_service, auth_service
     global user_service, multi_db_service, scylla_manager
+    global background_task_service
 
     embedding_service = None
     generation_service = None
@@ -384,6 +415,7 @@ def reset_services():
     user_service = None
     multi_db_service = None
     scylla_manager = None
+    background_task_service = None
 
     logger.info("All services reset")
 
@@ -394,6 +426,7 @@ __all__ = [
     "get_knowledge_service",
     "get_chatbot_service",
     "get_billing_service",
+    "get_background_task_service",
     "get_auth_service",
     "get_user_service",
     "get_multi_db_service",
@@ -409,6 +442,7 @@ __all__ = [
     "auth_service",
     "user_service",
     "multi_db_service",
+    "background_task_service",
     "scylla_manager",
     "reset_services",
 ]
@@ -12,7 +12,7 @@ except ImportError:
     KnowledgeService = None
     SearchConfig = None
 
-from .background_tasks import BackgroundTaskService, TaskResult
+from .background_tasks import BackgroundTaskService, TaskQuotaExceededError, TaskResult
 from .request_analyzer import RequestAnalyzer, RequestAnalysis, TaskComplexity
 from .timeout_processor import TimeoutProcessor, TimeoutConfig
 from .auth_service import auth_service
@@ -26,6 +26,7 @@ __all__ = [
     "KnowledgeService",
     "SearchConfig",
     "BackgroundTaskService",
+    "TaskQuotaExceededError",
     "TaskResult",
     "RequestAnalyzer",
     "RequestAnalysis",
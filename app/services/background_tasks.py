@@ -1,17 +1,39 @@
 """Background task processing service for long-running operations."""
 
+import threading
 import time
 import logging
 import uuid
-from typing import Dict, Any, Optional
+from typing import Callable, Dict, Any, Optional, TYPE_CHECKING
 from datetime import datetime, timezone
 from dataclasses import dataclass
 from concurrent.futures import ThreadPoolExecutor, Future
 
+from app.config import config
 from app.database.redis_models import NotificationModel, AnalyticsModel
 
+if TYPE_CHECKING:
+    from sqlalchemy.ext.asyncio import AsyncSession
+    from app.database.postgres_models import User
+    from app.services.billing_service import EnhancedBillingService
+
 logger = logging.getLogger(__name__)
 
+TASK_QUOTA_RESOURCE = "background_tasks"
+
+
+class TaskQuotaExceededError(PermissionError):
+    """Raised when a user's plan has no background task quota left"""
+
+    def __init__(self, plan: str, current_usage: int, max_allowed: int):
+        self.plan = plan
+        self.current_usage = current_usage
+        self.max_allowed = max_allowed
+        super().__init__(
+            f"Background task quota exceeded. Used {current_usage}/{max_allowed} "
+            f"for your {plan} plan. Upgrade for more tasks."
+        )
+
 
 @dataclass
 class TaskResult:
@@ -24,58 +46,168 @@ class TaskResult:
     duration_seconds: float = 0.0
 
 
+DEFAULT_WORKER_GROUP = "default"
+
+
+def parse_worker_groups(spec: str) -> Dict[str, int]:
+    """Parse a "research=2,data_analysis=1" spec into worker counts per group"""
+    groups: Dict[str, int] = {}
+    for entry in spec.split(","):
+        name, _, workers = entry.partition("=")
+        name = name.strip()
+        if not name:
+            continue
+        try:
+            groups[name] = max(1, int(workers))
+        except ValueError:
+            logger.warning(f"Ignoring invalid worker group entry: {entry!r}")
+    groups.setdefault(DEFAULT_WORKER_GROUP, 1)
+    return groups
+
+
+@dataclass
+class WorkerGroupStats:
+    """Counters for one worker group"""
+
+    workers: int
+    submitted: int = 0
+    running: int = 0
+    completed: int = 0
+    failed: int = 0
+
+
 class BackgroundTaskService:
     """Service for handling long-running background tasks."""
 
-    def __init__(self):
+    def __init__(
+        self,
+        worker_groups: Optional[Dict[str, int]] = None,
+        billing_service: Optional["EnhancedBillingService"] = None,
+    ):
+        self.billing_service = billing_service
         self.notification_model = NotificationModel()
         self.analytics_model = AnalyticsModel()
 
-        # Simple thread pool - let Python handle threading automatically
-        self.executor = ThreadPoolExecutor(max_workers=3, thread_name_prefix="bg_task")
+        # One thread pool per task type, so heavy research tasks can't
+        # starve lighter work queued behind them
+        groups = worker_groups or parse_worker_groups(config.background_worker_groups)
+        groups.setdefault(DEFAULT_WORKER_GROUP, 1)
+        self.executors: Dict[str, ThreadPoolExecutor] = {
+            name: ThreadPoolExecutor(
+                max_workers=workers, thread_name_prefix=f"bg_task_{name}"
+            )
+            for name, workers in groups.items()
+        }
+        self._group_stats: Dict[str, WorkerGroupStats] = {
+            name: WorkerGroupStats(workers=workers) for name, workers in groups.items()
+        }
+        self._stats_lock = threading.Lock()
 
         # Track running tasks
         self._running_tasks: Dict[str, Future] = {}
 
-    def submit_data_analysis_task(
-        self, user_id: str, data_description: str, session_id: str
-    ) -> str:
-        """Submit a data analysis task for background processing."""
-        task_id = str(uuid.uuid4())
+    def _submit(self, task_type: str, fn: Callable[..., Any], *args: Any) -> Future:
+        """Run fn on the worker group for task_type (or the default group)"""
+        group = task_type if task_type in self.executors else DEFAULT_WORKER_GROUP
+        stats = self._group_stats[group]
 
-        # Submit task to thread pool
-        future = self.executor.submit(
-            self._process_data_analysis, task_id, user_id, data_description, session_id
-        )
+        def run() -> Any:
+            with self._stats_lock:
+                stats.running += 1
+            try:
+                result = fn(*args)
+            except Exception:
+                with self._stats_lock:
+                    stats.running -= 1
+                    stats.failed += 1
+                raise
+            with self._stats_lock:
+                stats.running -= 1
+                if getattr(result, "success", True):
+                    stats.completed += 1
+                else:
+                    stats.failed += 1
+            return result
+
+        with self._stats_lock:
+            stats.submitted += 1
+        return self.executors[group].submit(run)
+
+    def get_worker_stats(self) -> Dict[str, Dict[str, int]]:
+        """Per-group worker counts and task counters"""
+        with self._stats_lock:
+            return {
+                name: {
+                    "workers": stats.workers,
+                    "submitted": stats.submitted,
+                    "running": stats.running,
+                    "queued": stats.submitted
+                    - stats.running
+                    - stats.completed
+                    - stats.failed,
+                    "completed": stats.completed,
+                    "failed": stats.failed,
+                }
+                for name, stats in self._group_stats.items()
+            }
 
-        # Track the task
-        self._running_tasks[task_id] = future
+    def _get_billing_service(self) -> "EnhancedBillingService":
+        if self.billing_service is None:
+            from app.dependencies import get_billing_service
 
-        # Record analytics
-        self.analytics_model.increment_counter("background_tasks_submitted")
-        self.analytics_model.record_event(
-            "task_submitted",
-            {
-                "task_id": task_id,
-                "task_type": "data_analysis",
-                "user_id": user_id,
-                "timestamp": datetime.now(timezone.utc).isoformat(),
-            },
-        )
+            self.billing_service = get_billing_service()
+        return self.billing_service
 
-        logger.info(f"📋 Submitted data analysis task {task_id} for user {user_id}")
-        return task_id
+    async def _reserve_task_quota(
+        self, user: "User", task_type: str, db_session: "AsyncSession"
+    ) -> Dict[str, Any]:
+        """
+        Charge one background task against the user's plan quota.
 
-    def submit_research_task(
-        self, user_id: str, research_topic: str, session_id: str
+        Raises TaskQuotaExceededError when the plan's allowance for the billing
+        period is used up. Billing errors propagate rather than failing open,
+        since an unchecked task costs real compute.
+        """
+        reservation = await self._get_billing_service().reserve_usage(
+            user,
+            TASK_QUOTA_RESOURCE,
+            db_session,
+            extra_data={"task_type": task_type},
+            operation_id=str(uuid.uuid4()),
+        )
+        if not reservation.get("has_quota"):
+            raise TaskQuotaExceededError(
+                user.subscription_plan,
+                reservation.get("current_usage", 0),
+                reservation.get("max_allowed", 0),
+            )
+        return reservation
+
+    async def _submit_with_quota(
+        self,
+        user: "User",
+        task_type: str,
+        fn: Callable[..., Any],
+        payload: str,
+        session_id: str,
+        db_session: "AsyncSession",
     ) -> str:
-        """Submit a research task for background processing."""
+        """Reserve a background task for the user, then hand fn to its worker group"""
+        reservation = await self._reserve_task_quota(user, task_type, db_session)
+        user_id = str(user.id)
         task_id = str(uuid.uuid4())
-
-        # Submit task to thread pool
-        future = self.executor.submit(
-            self._process_research_task, task_id, user_id, research_topic, session_id
-        )
+        try:
+            future = self._submit(task_type, fn, task_id, user_id, payload, session_id)
+        except Exception:
+            # Nothing was queued, so don't charge for it
+            await self._get_billing_service().release_usage(
+                user,
+                TASK_QUOTA_RESOURCE,
+                reservation["operation_id"],
+                db_session,
+                reason="submit_failed",
+            )
+            raise
 
         # Track the task
         self._running_tasks[task_id] = future
@@ -86,13 +218,49 @@ class BackgroundTaskService:
             "task_submitted",
             {
                 "task_id": task_id,
-                "task_type": "research",
+                "task_type": task_type,
                 "user_id": user_id,
                 "timestamp": datetime.now(timezone.utc).isoformat(),
             },
         )
+        return task_id
 
-        logger.info(f"🔍 Submitted research task {task_id} for user {user_id}")
+    async def submit_data_analysis_task(
+        self,
+        user: "User",
+        data_description: str,
+        session_id: str,
+        db_session: "AsyncSession",
+    ) -> str:
+        """Submit a data analysis task, charged to the user's background task quota."""
+        task_id = await self._submit_with_quota(
+            user,
+            "data_analysis",
+            self._process_data_analysis,
+            data_description,
+            session_id,
+            db_session,
+        )
+        logger.info(f"📋 Submitted data analysis task {task_id} for user {user.id}")
+        return task_id
+
+    async def submit_research_task(
+        self,
+        user: "User",
+        research_topic: str,
+        session_id: str,
+        db_session: "AsyncSession",
+    ) -> str:
+        """Submit a research task, charged to the user's background task quota."""
+        task_id = await self._submit_with_quota(
+            user,
+            "research",
+            self._process_research_task,
+            research_topic,
+            session_id,
+            db_session,
+        )
+        logger.info(f"🔍 Submitted research task {task_id} for user {user.id}")
         return task_id
 
     def _process_data_analysis(
@@ -425,13 +593,15 @@ class BackgroundTaskService:
             self._running_tasks.clear()
 
             # Force immediate shutdown without waiting
-            self.executor.shutdown(wait=False)
+            for executor in self.executors.values():
+                executor.shutdown(wait=False)
 
             logger.info("Background task service shutdown complete")
         except Exception as e:
             logger.error(f"Error during background task shutdown: {e}")
             # Force shutdown anyway
-            try:
-                self.executor.shutdown(wait=False)
-            except Exception:
-                pass
+            for executor in self.executors.values():
+                try:
+                    executor.shutdown(wait=False)
+                except Exception:
+                    pass
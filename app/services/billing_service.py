@@ -1,30 +1,93 @@
 """Enhanced Billing and subscription management service"""
 
+from dataclasses import dataclass
 from datetime import datetime, timezone, timedelta
-from typing import Dict, Any, Optional
+from typing import Dict, Any, List, Optional
+import hashlib
+import json
 import logging
 
 from sqlalchemy import select, func, and_
 from sqlalchemy.ext.asyncio import AsyncSession
 
-from app.database.postgres_models import User, Subscription, UsageRecord
-from app.database.redis_models import BillingCacheModel
+from app.config import config
+from app.database.postgres_models import (
+    User,
+    Subscription,
+    UsageRecord,
+    QuotaOverride,
+    AuditLog,
+)
+from app.database.redis_models import BillingCacheModel, NotificationModel
 
 logger = logging.getLogger(__name__)
 
+# Plan tiers from lowest to highest
+PLAN_HIERARCHY = {"free": 0, "pro": 1, "enterprise": 2}
+
+RESET_PERIODIC = "periodic"
+RESET_STANDING = "standing"
+RESET_ROLLOVER = "rollover"
+
+
+@dataclass(frozen=True)
+class ResetPolicy:
+    """
+    How a resource's quota usage resets between billing periods.
+
+    No job runs at period start. Quota checks work out the reset, and any
+    rollover carry-over from last period, from usage records when they run.
+    """
+
+    mode: str = RESET_PERIODIC
+    rollover_cap: int = 0
+
+
+def parse_reset_policies(spec: str) -> Dict[str, ResetPolicy]:
+    """Parse a "storage_mb=standing,messages=rollover:100" spec per resource"""
+    policies: Dict[str, ResetPolicy] = {}
+    for entry in spec.split(","):
+        resource, _, policy = entry.partition("=")
+        resource = resource.strip()
+        if not resource:
+            continue
+        mode, _, cap = policy.strip().partition(":")
+        try:
+            if mode == RESET_ROLLOVER:
+                policies[resource] = ResetPolicy(mode, max(0, int(cap)))
+            elif mode in (RESET_PERIODIC, RESET_STANDING):
+                policies[resource] = ResetPolicy(mode)
+            else:
+                raise ValueError(mode)
+        except ValueError:
+            logger.warning(f"Ignoring invalid quota reset policy: {entry!r}")
+    return policies
+
 
 class EnhancedBillingService:
     """Enhanced billing and subscription management service with caching."""
 
-    def __init__(self):
+    def __init__(self, reset_policies: Optional[Dict[str, ResetPolicy]] = None):
         self.cache = BillingCacheModel()
+        self.notifications = NotificationModel()
         self._plan_definitions = self._load_plan_definitions()
+        self._reset_policies = (
+            reset_policies
+            if reset_policies is not None
+            else parse_reset_policies(config.quota_reset_policies)
+        )
 
     def _load_plan_definitions(self) -> Dict[str, Dict[str, Any]]:
-        """Load subscription plan definitions"""
+        """
+        Load subscription plan definitions.
+
+        Bump a plan's updated_at whenever its terms change; it is served as
+        Last-Modified for the plans listing.
+        """
         return {
             "free": {
                 "name": "Free Plan",
+                "updated_at": datetime(2025, 8, 1, tzinfo=timezone.utc),
                 "limits": {
                     "messages": 10,
                     "background_tasks": 5,
@@ -40,6 +103,7 @@ class EnhancedBillingService:
             },
             "pro": {
                 "name": "Pro Plan",
+                "updated_at": datetime(2025, 8, 1, tzinfo=timezone.utc),
                 "limits": {
                     "messages": 1000,
                     "background_tasks": 50,
@@ -59,6 +123,7 @@ class EnhancedBillingService:
             },
             "enterprise": {
                 "name": "Enterprise Plan",
+                "updated_at": datetime(2025, 8, 1, tzinfo=timezone.utc),
                 "limits": {
                     "messages": 10000,
                     "background_tasks": 1000,
@@ -139,7 +204,8 @@ class EnhancedBillingService:
                 currency="USD",
                 started_at=datetime.now(timezone.utc),
                 auto_renew=True,
-                limits=plan_def["limits"],
+                limits=dict(plan_def["limits"]),
+                plan_version=self._get_plan_version(plan_type),
             )
 
             session.add(subscription)
@@ -175,7 +241,8 @@ class EnhancedBillingService:
                 currency="USD",
                 started_at=datetime.now(timezone.utc),
                 auto_renew=True,
-                limits=plan_def["limits"],
+                limits=dict(plan_def["limits"]),
+                plan_version=self._get_plan_version(plan_type),
             )
 
             # Update user's plan
@@ -268,8 +335,9 @@ class EnhancedBillingService:
             # Update the existing subscription object's attributes
             current_sub.plan_type = new_plan
             current_sub.billing_cycle = billing_cycle
-            current_sub.limits = self._get_plan_limits(new_plan)
+            current_sub.limits = dict(self._get_plan_limits(new_plan))
             current_sub.amount_cents = self._get_plan_price(new_plan, billing_cycle)
+            current_sub.plan_version = self._get_plan_version(new_plan)
             current_sub.updated_at = datetime.now(timezone.utc)
 
             # The user's plan should also be updated to stay in sync
@@ -332,6 +400,295 @@ class EnhancedBillingService:
             logger.error(f"Failed to cancel subscription: {e}")
             return {"success": False, "reason": str(e)}
 
+    async def migrate_subscription_to_current_plan(
+        self, user: User, session: AsyncSession
+    ) -> Optional[Subscription]:
+        """
+        Move a grandfathered subscription onto the current plan definition.
+
+        A subscription already on the current plan_version is returned as is.
+        """
+        try:
+            stmt = (
+                select(Subscription)
+                .where(
+                    and_(
+                        Subscription.user_id == user.id,
+                        Subscription.status.in_(["active", "trialing"]),
+                    )
+                )
+                .order_by(Subscription.created_at.desc())
+            )
+            result = await session.execute(stmt)
+            subscription = result.scalars().first()
+
+            if not subscription:
+                return None
+
+            current_version = self._get_plan_version(subscription.plan_type)
+            if subscription.plan_version == current_version:
+                return subscription
+
+            old_values = {
+                "limits": subscription.limits,
+                "amount_cents": subscription.amount_cents,
+                "plan_version": subscription.plan_version,
+            }
+
+            subscription.limits = dict(self._get_plan_limits(subscription.plan_type))
+            subscription.amount_cents = self._get_plan_price(
+                subscription.plan_type, subscription.billing_cycle
+            )
+            subscription.plan_version = current_version
+            subscription.updated_at = datetime.now(timezone.utc)
+
+            session.add(subscription)
+            session.add(
+                AuditLog(
+                    user_id=user.id,
+                    action="subscription_plan_migrated",
+                    resource_type="subscription",
+                    resource_id=str(subscription.id),
+                    old_values=old_values,
+                    new_values={
+                        "limits": subscription.limits,
+                        "amount_cents": subscription.amount_cents,
+                        "plan_version": subscription.plan_version,
+                    },
+                )
+            )
+            await session.commit()
+            await session.refresh(subscription)
+
+            await self.cache.invalidate_user_cache(str(user.id))
+
+            logger.info(
+                f"Migrated {subscription.plan_type} subscription for user {user.email} "
+                f"to current plan definition"
+            )
+            return subscription
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to migrate subscription: {e}")
+            return None
+
+    async def process_inactive_subscriptions(
+        self,
+        session: AsyncSession,
+        inactive_days: int,
+        grace_days: int,
+        now: Optional[datetime] = None,
+    ) -> Dict[str, Any]:
+        """
+        Flag and downgrade paid subscriptions that have gone unused.
+
+        A subscription with no recorded usage for inactive_days is flagged
+        and the subscriber notified. If it is still unused grace_days after
+        the flag, it is downgraded one tier. Subscriptions are never
+        cancelled; a downgrade restarts the inactivity clock for the next
+        tier. Every step is written to the audit log.
+        """
+        now = now or datetime.now(timezone.utc)
+        summary: Dict[str, Any] = {"flagged": [], "downgraded": [], "pending": []}
+
+        try:
+            result = await session.execute(
+                select(Subscription, User)
+                .join(User, User.id == Subscription.user_id)
+                .where(
+                    Subscription.status == "active",
+                    Subscription.plan_type.in_(
+                        [plan for plan, rank in PLAN_HIERARCHY.items() if rank > 0]
+                    ),
+                )
+            )
+
+            for subscription, user in result.all():
+                last_activity = await self._get_last_activity(
+                    user, subscription, session
+                )
+                last_event = await self._get_last_inactivity_event(
+                    subscription, last_activity, session
+                )
+
+                # A previous auto-downgrade restarts the clock for this tier
+                if last_event and last_event.action == "subscription_auto_downgraded":
+                    last_activity = last_event.created_at
+                    last_event = None
+
+                if now - last_activity < timedelta(days=inactive_days):
+                    continue
+
+                entry = {
+                    "subscription_id": str(subscription.id),
+                    "user_id": str(user.id),
+                    "plan_type": subscription.plan_type,
+                    "last_activity": last_activity.isoformat(),
+                }
+
+                if last_event is None:
+                    self._flag_inactive_subscription(
+                        user, subscription, last_activity, grace_days, now, session
+                    )
+                    summary["flagged"].append(entry)
+                elif now - last_event.created_at >= timedelta(days=grace_days):
+                    new_plan = self._auto_downgrade_subscription(
+                        user, subscription, last_activity, now, session
+                    )
+                    summary["downgraded"].append({**entry, "new_plan_type": new_plan})
+                else:
+                    summary["pending"].append(entry)
+
+            await session.commit()
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to process inactive subscriptions: {e}")
+            raise
+
+        for entry in summary["downgraded"]:
+            await self.cache.invalidate_user_cache(entry["user_id"])
+
+        logger.info(
+            f"Inactive subscriptions: {len(summary['flagged'])} flagged, "
+            f"{len(summary['downgraded'])} downgraded, "
+            f"{len(summary['pending'])} in grace period"
+        )
+        return summary
+
+    async def _get_last_activity(
+        self, user: User, subscription: Subscription, session: AsyncSession
+    ) -> datetime:
+        """Time of the user's latest recorded usage, or subscription start"""
+        result = await session.execute(
+            select(func.max(UsageRecord.created_at)).where(
+                UsageRecord.user_id == user.id,
+                UsageRecord.quantity > 0,
+            )
+        )
+        last_usage = result.scalar()
+        candidates = [subscription.started_at]
+        if last_usage:
+            candidates.append(last_usage)
+        return max(candidates)
+
+    async def _get_last_inactivity_event(
+        self, subscription: Subscription, since: datetime, session: AsyncSession
+    ) -> Optional[AuditLog]:
+        """Latest inactivity flag or auto-downgrade recorded after since"""
+        result = await session.execute(
+            select(AuditLog)
+            .where(
+                AuditLog.resource_type == "subscription",
+                AuditLog.resource_id == str(subscription.id),
+                AuditLog.action.in_(
+                    ["subscription_inactivity_flagged", "subscription_auto_downgraded"]
+                ),
+                AuditLog.created_at > since,
+            )
+            .order_by(AuditLog.created_at.desc())
+            .limit(1)
+        )
+        return result.scalar_one_or_none()
+
+    def _flag_inactive_subscription(
+        self,
+        user: User,
+        subscription: Subscription,
+        last_activity: datetime,
+        grace_days: int,
+        now: datetime,
+        session: AsyncSession,
+    ) -> None:
+        """Record an inactivity flag and warn the subscriber"""
+        downgrade_after = now + timedelta(days=grace_days)
+        session.add(
+            AuditLog(
+                user_id=user.id,
+                action="subscription_inactivity_flagged",
+                resource_type="subscription",
+                resource_id=str(subscription.id),
+                new_values={
+                    "plan_type": subscription.plan_type,
+                    "last_activity": last_activity.isoformat(),
+                    "downgrade_after": downgrade_after.isoformat(),
+                },
+                created_at=now,
+            )
+        )
+
+        self.notifications.add_notification(
+            str(user.id),
+            {
+                "type": "warning",
+                "title": "Your subscription has been inactive",
+                "message": (
+                    f"We haven't seen any usage on your {subscription.plan_type} "
+                    f"plan since {last_activity.date().isoformat()}. It will be "
+                    f"moved to a lower tier after {downgrade_after.date().isoformat()} "
+                    f"unless it is used again."
+                ),
+                "data": {
+                    "subscription_id": str(subscription.id),
+                    "downgrade_after": downgrade_after.isoformat(),
+                },
+            },
+        )
+        logger.info(
+            f"Flagged inactive {subscription.plan_type} subscription for user "
+            f"{user.email}"
+        )
+
+    def _auto_downgrade_subscription(
+        self,
+        user: User,
+        subscription: Subscription,
+        last_activity: datetime,
+        now: datetime,
+        session: AsyncSession,
+    ) -> str:
+        """Move an inactive subscription down one tier and audit it"""
+        old_values = {
+            "plan_type": subscription.plan_type,
+            "limits": subscription.limits,
+            "amount_cents": subscription.amount_cents,
+        }
+        new_plan = self._next_lower_plan(subscription.plan_type) or "free"
+
+        subscription.plan_type = new_plan
+        subscription.limits = dict(self._get_plan_limits(new_plan))
+        subscription.amount_cents = self._get_plan_price(
+            new_plan, subscription.billing_cycle
+        )
+        subscription.plan_version = self._get_plan_version(new_plan)
+        subscription.updated_at = now
+        user.subscription_plan = new_plan
+
+        session.add(subscription)
+        session.add(user)
+        session.add(
+            AuditLog(
+                user_id=user.id,
+                action="subscription_auto_downgraded",
+                resource_type="subscription",
+                resource_id=str(subscription.id),
+                old_values=old_values,
+                new_values={
+                    "plan_type": new_plan,
+                    "limits": subscription.limits,
+                    "amount_cents": subscription.amount_cents,
+                    "last_activity": last_activity.isoformat(),
+                },
+                created_at=now,
+            )
+        )
+        logger.info(
+            f"Auto-downgraded inactive subscription for user {user.email} "
+            f"from {old_values['plan_type']} to {new_plan}"
+        )
+        return new_plan
+
     async def check_user_quota(
         self,
         user: User,
@@ -345,35 +702,18 @@ class EnhancedBillingService:
             if cached:
                 return cached
 
-            # Get current billing period
-            now = datetime.now(timezone.utc)
-            period_start = now.replace(day=1, hour=0, minute=0, second=0, microsecond=0)
+            period_start, period_end = self._current_billing_period()
 
-            # Calculate period end properly
-            if now.month == 12:
-                period_end = datetime(
-                    now.year + 1, 1, 1, tzinfo=timezone.utc
-                ) - timedelta(seconds=1)
-            else:
-                period_end = datetime(
-                    now.year, now.month + 1, 1, tzinfo=timezone.utc
-                ) - timedelta(seconds=1)
+            # Limits come from the subscription so plan changes are grandfathered
+            overrides = await self._get_active_overrides(user, session)
+            limits = await self._get_effective_limits(user, session, overrides)
+            override = overrides.get(resource_type)
 
-            # Get usage for current period
-            result = await session.execute(
-                select(func.sum(UsageRecord.quantity)).where(
-                    UsageRecord.user_id == user.id,
-                    UsageRecord.resource_type == resource_type,
-                    UsageRecord.billing_period_start >= period_start,
-                    UsageRecord.billing_period_end <= period_end,
-                )
+            policy = self._reset_policies.get(resource_type, ResetPolicy())
+            current_usage, carried_over = await self._get_quota_usage(
+                user, resource_type, session, limits.get(resource_type, 1000)
             )
-            current_usage = result.scalar() or 0
-
-            # Use user's subscription_plan field directly for limits
-            plan_type = user.subscription_plan or "free"
-            limits = self._get_plan_limits(plan_type)
-            max_allowed = limits.get(resource_type, 1000)
+            max_allowed = limits.get(resource_type, 1000) + carried_over
 
             has_quota = int(current_usage) < max_allowed
 
@@ -384,14 +724,25 @@ class EnhancedBillingService:
                 "remaining": max(0, max_allowed - int(current_usage)),
                 "period_start": period_start.isoformat(),
                 "period_end": period_end.isoformat(),
+                "reset_policy": policy.mode,
+                "carried_over": carried_over,
+                "override_expires_at": override.expires_at.isoformat()
+                if override
+                else None,
             }
 
-            # Cache the result
+            # Cache the result, but not past the expiry of an override
+            ttl = 300  # 5 minutes
+            if override:
+                remaining_seconds = (
+                    override.expires_at - datetime.now(timezone.utc)
+                ).total_seconds()
+                ttl = max(1, min(ttl, int(remaining_seconds)))
             await self.cache.cache_quota(
                 str(user.id),
                 resource_type,
                 quota_info,
-                ttl=300,  # 5 minutes
+                ttl=ttl,
             )
 
             return quota_info
@@ -418,11 +769,7 @@ class EnhancedBillingService:
     ) -> bool:
         """Record resource usage for billing - session parameter is required."""
         try:
-            now = datetime.now(timezone.utc)
-            period_start = now.replace(day=1, hour=0, minute=0, second=0, microsecond=0)
-            period_end = (period_start + timedelta(days=32)).replace(day=1) - timedelta(
-                seconds=1
-            )
+            period_start, period_end = self._current_billing_period()
 
             usage_record = UsageRecord(
                 user_id=user.id,
@@ -449,6 +796,459 @@ class EnhancedBillingService:
             await session.rollback()
             return False
 
+    async def reserve_usage(
+        self,
+        user: User,
+        resource_type: str,
+        session: AsyncSession,
+        quantity: int = 1,
+        extra_data: Optional[Dict[str, Any]] = None,
+        operation_id: Optional[str] = None,
+    ) -> Dict[str, Any]:
+        """
+        Atomically check remaining quota and record usage.
+
+        A transaction-scoped advisory lock per user/resource serialises concurrent
+        reservations, so parallel requests cannot both pass the check and overshoot
+        the quota the way separate check and record calls can. Passing an
+        operation_id lets the usage be credited back with release_usage.
+        """
+        try:
+            await session.execute(
+                select(
+                    func.pg_advisory_xact_lock(
+                        self._quota_lock_key(user.id, resource_type)
+                    )
+                )
+            )
+
+            period_start, period_end = self._current_billing_period()
+
+            limits = await self._get_effective_limits(user, session)
+            current_usage, carried_over = await self._get_quota_usage(
+                user, resource_type, session, limits.get(resource_type, 1000)
+            )
+            max_allowed = limits.get(resource_type, 1000) + carried_over
+
+            reserved = current_usage + quantity <= max_allowed
+            if reserved:
+                session.add(
+                    UsageRecord(
+                        user_id=user.id,
+                        resource_type=resource_type,
+                        quantity=quantity,
+                        billing_period_start=period_start,
+                        billing_period_end=period_end,
+                        extra_data={
+                            **(extra_data or {}),
+                            **({"operation_id": operation_id} if operation_id else {}),
+                        },
+                    )
+                )
+                current_usage += quantity
+
+            # Committing also releases the advisory lock
+            await session.commit()
+
+            if reserved:
+                await self.cache.invalidate_quota_cache(str(user.id), resource_type)
+
+            return {
+                "reserved": reserved,
+                "has_quota": reserved,
+                "operation_id": operation_id if reserved else None,
+                "current_usage": current_usage,
+                "max_allowed": max_allowed,
+                "remaining": max(0, max_allowed - current_usage),
+                "period_start": period_start.isoformat(),
+                "period_end": period_end.isoformat(),
+            }
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to reserve usage: {e}")
+            raise
+
+    async def release_usage(
+        self,
+        user: User,
+        resource_type: str,
+        operation_id: str,
+        session: AsyncSession,
+        reason: Optional[str] = None,
+    ) -> Dict[str, Any]:
+        """
+        Credit back usage recorded under operation_id when the operation failed.
+
+        The credit is written as a negative usage record in the original billing
+        period, bounded so the period total never drops below zero. Releasing
+        the same operation twice is a no-op.
+        """
+        try:
+            await session.execute(
+                select(
+                    func.pg_advisory_xact_lock(
+                        self._quota_lock_key(user.id, resource_type)
+                    )
+                )
+            )
+
+            operation_filter = and_(
+                UsageRecord.user_id == user.id,
+                UsageRecord.resource_type == resource_type,
+            )
+
+            already_released = await session.scalar(
+                select(func.count(UsageRecord.id)).where(
+                    operation_filter,
+                    UsageRecord.extra_data["released_operation_id"].astext
+                    == operation_id,
+                )
+            )
+            if already_released:
+                await session.commit()
+                return {
+                    "released": False,
+                    "already_released": True,
+                    "quantity_released": 0,
+                }
+
+            result = await session.execute(
+                select(UsageRecord).where(
+                    operation_filter,
+                    UsageRecord.extra_data["operation_id"].astext == operation_id,
+                )
+            )
+            recorded = result.scalars().all()
+            if not recorded:
+                await session.commit()
+                logger.warning(
+                    f"No {resource_type} usage recorded for operation {operation_id}"
+                )
+                return {
+                    "released": False,
+                    "already_released": False,
+                    "quantity_released": 0,
+                }
+
+            period_start = recorded[0].billing_period_start
+            period_end = recorded[0].billing_period_end
+            current_usage = await self._get_period_usage(
+                user, resource_type, session, period_start, period_end
+            )
+            credit = min(sum(r.quantity for r in recorded), max(0, current_usage))
+
+            # Recorded even when the credit is zero so a retry stays a no-op
+            session.add(
+                UsageRecord(
+                    user_id=user.id,
+                    resource_type=resource_type,
+                    quantity=-credit,
+                    billing_period_start=period_start,
+                    billing_period_end=period_end,
+                    extra_data={
+                        "released_operation_id": operation_id,
+                        "reason": reason,
+                    },
+                )
+            )
+            await session.commit()
+
+            await self.cache.invalidate_quota_cache(str(user.id), resource_type)
+            logger.info(
+                f"Released {credit} {resource_type} for user {user.id} "
+                f"(operation {operation_id})"
+            )
+
+            return {
+                "released": True,
+                "already_released": False,
+                "quantity_released": credit,
+                "current_usage": current_usage - credit,
+            }
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to release usage: {e}")
+            raise
+
+    async def grant_quota_override(
+        self,
+        user: User,
+        resource_type: str,
+        max_allowed: int,
+        expires_at: datetime,
+        reason: str,
+        granted_by: User,
+        session: AsyncSession,
+    ) -> QuotaOverride:
+        """Temporarily raise (or set) a user's limit for one resource"""
+        try:
+            override = QuotaOverride(
+                user_id=user.id,
+                resource_type=resource_type,
+                max_allowed=max_allowed,
+                reason=reason,
+                granted_by=granted_by.id,
+                expires_at=expires_at,
+            )
+            session.add(override)
+            await session.flush()
+
+            session.add(
+                AuditLog(
+                    user_id=granted_by.id,
+                    action="quota_override_granted",
+                    resource_type="quota_override",
+                    resource_id=str(override.id),
+                    new_values={
+                        "user_id": str(user.id),
+                        "resource_type": resource_type,
+                        "max_allowed": max_allowed,
+                        "expires_at": expires_at.isoformat(),
+                        "reason": reason,
+                    },
+                )
+            )
+            await session.commit()
+            await session.refresh(override)
+
+            await self.cache.invalidate_user_cache(str(user.id))
+
+            logger.info(
+                f"Granted {resource_type} override of {max_allowed} to user "
+                f"{user.email} until {expires_at.isoformat()}"
+            )
+            return override
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to grant quota override: {e}")
+            raise
+
+    async def revoke_quota_override(
+        self, override_id: Any, revoked_by: User, session: AsyncSession
+    ) -> Optional[QuotaOverride]:
+        """End an override early; returns None if it doesn't exist"""
+        try:
+            override = await session.get(QuotaOverride, override_id)
+            if not override:
+                return None
+            if override.revoked_at is not None:
+                return override
+
+            override.revoked_at = datetime.now(timezone.utc)
+            session.add(
+                AuditLog(
+                    user_id=revoked_by.id,
+                    action="quota_override_revoked",
+                    resource_type="quota_override",
+                    resource_id=str(override.id),
+                    old_values={
+                        "user_id": str(override.user_id),
+                        "resource_type": override.resource_type,
+                        "max_allowed": override.max_allowed,
+                        "expires_at": override.expires_at.isoformat(),
+                    },
+                )
+            )
+            await session.commit()
+
+            await self.cache.invalidate_user_cache(str(override.user_id))
+            return override
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to revoke quota override: {e}")
+            raise
+
+    async def detect_usage_anomalies(
+        self,
+        session: AsyncSession,
+        spike_multiplier: float,
+        baseline_periods: int = 3,
+        min_usage: int = 50,
+        throttle_hours: Optional[int] = None,
+        now: Optional[datetime] = None,
+    ) -> Dict[str, Any]:
+        """
+        Flag users whose usage is spiking against their own history.
+
+        Each user's daily usage rate for a resource so far this period is
+        compared with their daily rate over the previous baseline_periods
+        billing periods. Usage above spike_multiplier times that baseline
+        (and at least min_usage) is flagged once per period, audited and
+        notified. Users without history have no baseline and are skipped.
+        With throttle_hours set, flagged users are also held at their
+        current usage by a temporary quota override; for standing resources
+        that is the all-time total the quota counts. Notifications go out
+        only once the flags are committed.
+        """
+        now = now or datetime.now(timezone.utc)
+        period_start, period_end = self._current_billing_period(now)
+
+        baseline_start = period_start
+        for _ in range(baseline_periods):
+            baseline_start, _end = self._current_billing_period(
+                baseline_start - timedelta(seconds=1)
+            )
+
+        elapsed_days = max((now - period_start).total_seconds() / 86400, 1.0)
+        baseline_days = (period_start - baseline_start).total_seconds() / 86400
+
+        summary: Dict[str, Any] = {
+            "period_start": period_start.isoformat(),
+            "flagged": [],
+        }
+
+        try:
+            current = await self._sum_usage_by_user(
+                session, period_start, period_end
+            )
+            baseline = await self._sum_usage_by_user(
+                session, baseline_start, period_start - timedelta(seconds=1)
+            )
+            standing_totals = await self._sum_usage_by_user(
+                session,
+                resource_types=[
+                    resource
+                    for resource, policy in self._reset_policies.items()
+                    if policy.mode == RESET_STANDING
+                ],
+            )
+
+            for (user_id, resource_type), used in current.items():
+                history = baseline.get((user_id, resource_type), 0)
+                if used < min_usage or history <= 0:
+                    continue
+
+                current_rate = used / elapsed_days
+                baseline_rate = history / baseline_days
+                if current_rate <= spike_multiplier * baseline_rate:
+                    continue
+
+                marker = f"{resource_type}:{period_start.date().isoformat()}"
+                already_flagged = await session.execute(
+                    select(AuditLog.id)
+                    .where(
+                        AuditLog.user_id == user_id,
+                        AuditLog.action == "usage_anomaly_flagged",
+                        AuditLog.resource_id == marker,
+                    )
+                    .limit(1)
+                )
+                if already_flagged.scalar_one_or_none():
+                    continue
+
+                # Standing quotas count every record, not just this period
+                quota_usage = standing_totals.get((user_id, resource_type), used)
+
+                user = await session.get(User, user_id)
+                entry = {
+                    "user_id": str(user_id),
+                    "resource_type": resource_type,
+                    "current_usage": used,
+                    "quota_usage": quota_usage,
+                    "current_daily_rate": round(current_rate, 2),
+                    "baseline_daily_rate": round(baseline_rate, 2),
+                    "throttled": throttle_hours is not None,
+                }
+
+                session.add(
+                    AuditLog(
+                        user_id=user_id,
+                        action="usage_anomaly_flagged",
+                        resource_type="usage",
+                        resource_id=marker,
+                        new_values={**entry, "spike_multiplier": spike_multiplier},
+                        created_at=now,
+                    )
+                )
+
+                if throttle_hours is not None:
+                    session.add(
+                        QuotaOverride(
+                            user_id=user_id,
+                            resource_type=resource_type,
+                            max_allowed=quota_usage,
+                            kind="throttle",
+                            expires_at=now + timedelta(hours=throttle_hours),
+                            reason="Automatic throttle after usage anomaly",
+                        )
+                    )
+
+                logger.warning(
+                    f"Usage anomaly for user {user.email if user else user_id}: "
+                    f"{resource_type} at {current_rate:.1f}/day vs baseline "
+                    f"{baseline_rate:.1f}/day"
+                )
+                summary["flagged"].append(entry)
+
+            await session.commit()
+
+        except Exception as e:
+            await session.rollback()
+            logger.error(f"Failed to detect usage anomalies: {e}")
+            raise
+
+        # Only tell users about flags that were actually recorded
+        for entry in summary["flagged"]:
+            self.notifications.add_notification(
+                entry["user_id"],
+                {
+                    "type": "warning",
+                    "title": "Unusual account activity",
+                    "message": (
+                        f"Your {entry['resource_type']} usage this period is well "
+                        f"above your usual rate. If this wasn't you, change your "
+                        f"password and contact support."
+                    ),
+                    "data": entry,
+                },
+            )
+            if throttle_hours is not None:
+                await self.cache.invalidate_user_cache(entry["user_id"])
+
+        return summary
+
+    async def _sum_usage_by_user(
+        self,
+        session: AsyncSession,
+        period_start: Optional[datetime] = None,
+        period_end: Optional[datetime] = None,
+        resource_types: Optional[List[str]] = None,
+    ) -> Dict[tuple, int]:
+        """
+        Total usage per (user_id, resource_type) for periods in the range.
+
+        Without a range every record counts; resource_types narrows the
+        resources summed, and an empty list sums none.
+        """
+        if resource_types is not None and not resource_types:
+            return {}
+
+        conditions = []
+        if period_start is not None:
+            conditions.append(UsageRecord.billing_period_start >= period_start)
+        if period_end is not None:
+            conditions.append(UsageRecord.billing_period_end <= period_end)
+        if resource_types is not None:
+            conditions.append(UsageRecord.resource_type.in_(resource_types))
+
+        result = await session.execute(
+            select(
+                UsageRecord.user_id,
+                UsageRecord.resource_type,
+                func.sum(UsageRecord.quantity),
+            )
+            .where(*conditions)
+            .group_by(UsageRecord.user_id, UsageRecord.resource_type)
+        )
+        return {
+            (user_id, resource_type): int(total or 0)
+            for user_id, resource_type, total in result.all()
+        }
+
     async def get_usage_summary(
         self,
         user: User,
@@ -493,9 +1293,8 @@ class EnhancedBillingService:
                 total = row.total if row.total is not None else 0
                 usage_data[row.resource_type] = int(total)
 
-            # Use user's subscription_plan field directly
             plan_type = user.subscription_plan or "free"
-            limits = self._get_plan_limits(plan_type)
+            limits = await self._get_effective_limits(user, session)
 
             summary = {
                 "messages_this_month": usage_data.get("messages", 0),
@@ -659,16 +1458,193 @@ class EnhancedBillingService:
 
         return {"plans": plans, "currency": "USD"}
 
+    def plans_last_modified(self) -> datetime:
+        """When any plan definition last changed"""
+        return max(plan["updated_at"] for plan in self._plan_definitions.values())
+
+    async def _get_effective_limits(
+        self,
+        user: User,
+        session: AsyncSession,
+        overrides: Optional[Dict[str, QuotaOverride]] = None,
+    ) -> Dict[str, int]:
+        """
+        Get the limits the user's subscription was created under.
+
+        Subscriptions snapshot their plan's limits, so later changes to the plan
+        definitions only apply once the subscription is migrated. Resources the
+        snapshot predates fall back to the current plan defaults. Active admin
+        overrides take precedence over both.
+        """
+        plan_defaults = self._get_plan_limits(user.subscription_plan or "free")
+
+        subscribed_limits = None
+        cached = await self.cache.get_cached_subscription(str(user.id))
+        if cached:
+            subscribed_limits = cached.get("limits")
+        else:
+            result = await session.execute(
+                select(Subscription.limits)
+                .where(
+                    and_(
+                        Subscription.user_id == user.id,
+                        Subscription.status.in_(["active", "trialing"]),
+                    )
+                )
+                .order_by(Subscription.created_at.desc())
+                .limit(1)
+            )
+            subscribed_limits = result.scalar_one_or_none()
+
+        if overrides is None:
+            overrides = await self._get_active_overrides(user, session)
+
+        return {
+            **plan_defaults,
+            **(subscribed_limits or {}),
+            **{resource: o.max_allowed for resource, o in overrides.items()},
+        }
+
+    async def _get_active_overrides(
+        self, user: User, session: AsyncSession
+    ) -> Dict[str, QuotaOverride]:
+        """
+        Get the unexpired, unrevoked override per resource.
+
+        A throttle always beats a grant, so raising a limit can't lift an
+        anomaly throttle; among throttles the lowest limit wins, among grants
+        the highest.
+        """
+        result = await session.execute(
+            select(QuotaOverride).where(
+                and_(
+                    QuotaOverride.user_id == user.id,
+                    QuotaOverride.revoked_at.is_(None),
+                    QuotaOverride.expires_at > datetime.now(timezone.utc),
+                )
+            )
+        )
+
+        def precedence(override: QuotaOverride) -> tuple:
+            if override.kind == "throttle":
+                return (1, -override.max_allowed)
+            return (0, override.max_allowed)
+
+        overrides: Dict[str, QuotaOverride] = {}
+        for override in sorted(result.scalars().all(), key=precedence):
+            overrides[override.resource_type] = override
+        return overrides
+
+    @staticmethod
+    def _current_billing_period(
+        now: Optional[datetime] = None,
+    ) -> tuple[datetime, datetime]:
+        """Get the start and end of the monthly billing period containing now"""
+        now = now or datetime.now(timezone.utc)
+        period_start = now.replace(day=1, hour=0, minute=0, second=0, microsecond=0)
+        period_end = (period_start + timedelta(days=32)).replace(day=1) - timedelta(
+            seconds=1
+        )
+        return period_start, period_end
+
+    async def _get_period_usage(
+        self,
+        user: User,
+        resource_type: str,
+        session: AsyncSession,
+        period_start: datetime,
+        period_end: datetime,
+    ) -> int:
+        """Sum recorded usage of a resource within a billing period"""
+        result = await session.execute(
+            select(func.sum(UsageRecord.quantity)).where(
+                UsageRecord.user_id == user.id,
+                UsageRecord.resource_type == resource_type,
+                UsageRecord.billing_period_start >= period_start,
+                UsageRecord.billing_period_end <= period_end,
+            )
+        )
+        return int(result.scalar() or 0)
+
+    async def _get_quota_usage(
+        self,
+        user: User,
+        resource_type: str,
+        session: AsyncSession,
+        limit: int,
+        now: Optional[datetime] = None,
+    ) -> tuple[int, int]:
+        """
+        Get usage counted against a resource's quota and any carried-over units.
+
+        Periodic resources count only the current billing period. Standing
+        resources (e.g. storage) count every record ever, so they never reset.
+        Rollover resources count the current period, plus up to the policy cap
+        of units left unused last period (measured against today's limit),
+        if the user already existed then.
+        """
+        policy = self._reset_policies.get(resource_type, ResetPolicy())
+        period_start, period_end = self._current_billing_period(now)
+
+        if policy.mode == RESET_STANDING:
+            result = await session.execute(
+                select(func.sum(UsageRecord.quantity)).where(
+                    UsageRecord.user_id == user.id,
+                    UsageRecord.resource_type == resource_type,
+                )
+            )
+            return int(result.scalar() or 0), 0
+
+        current_usage = await self._get_period_usage(
+            user, resource_type, session, period_start, period_end
+        )
+
+        carried_over = 0
+        created_at = getattr(user, "created_at", None)
+        if policy.mode == RESET_ROLLOVER and (
+            created_at is None or created_at < period_start
+        ):
+            previous_start, previous_end = self._current_billing_period(
+                period_start - timedelta(seconds=1)
+            )
+            previous_usage = await self._get_period_usage(
+                user, resource_type, session, previous_start, previous_end
+            )
+            carried_over = min(policy.rollover_cap, max(0, limit - previous_usage))
+
+        return current_usage, carried_over
+
+    @staticmethod
+    def _quota_lock_key(user_id: Any, resource_type: str) -> int:
+        """Derive a stable signed 64-bit advisory lock key for a user/resource"""
+        digest = hashlib.sha256(f"quota:{user_id}:{resource_type}".encode()).digest()
+        return int.from_bytes(digest[:8], "big", signed=True)
+
     def _get_plan_limits(self, plan_type: str) -> Dict[str, int]:
         """Get resource limits for subscription plan"""
         return self._plan_definitions.get(plan_type, self._plan_definitions["free"])[
             "limits"
         ]
 
+    def _get_plan_version(self, plan_type: str) -> str:
+        """Fingerprint a plan's limits and pricing so changed terms are detectable"""
+        plan_def = self._plan_definitions.get(plan_type, self._plan_definitions["free"])
+        terms = json.dumps(
+            {"limits": plan_def["limits"], "pricing": plan_def["pricing"]},
+            sort_keys=True,
+        )
+        return hashlib.sha256(terms.encode()).hexdigest()[:16]
+
     def _is_downgrade(self, current_plan: str, new_plan: str) -> bool:
         """Check if plan change is a downgrade"""
-        plan_hierarchy = {"free": 0, "pro": 1, "enterprise": 2}
-        return plan_hierarchy.get(new_plan, 0) < plan_hierarchy.get(current_plan, 0)
+        return PLAN_HIERARCHY.get(new_plan, 0) < PLAN_HIERARCHY.get(current_plan, 0)
+
+    @staticmethod
+    def _next_lower_plan(plan_type: str) -> Optional[str]:
+        """Get the plan one tier below plan_type, or None if already lowest"""
+        tier = PLAN_HIERARCHY.get(plan_type, 0)
+        lower = [plan for plan, rank in PLAN_HIERARCHY.items() if rank == tier - 1]
+        return lower[0] if lower else None
 
     async def _check_downgrade_eligibility(
         self, user: User, current_plan: str, new_plan: str
@@ -8,6 +8,8 @@ import time
 from dataclasses import dataclass
 from typing import Any, Dict, List, Optional, Tuple, Callable
 
+from app.core.overload import ServiceOverloadedError
+
 # Import enhanced services
 from app.services.knowledge_service import KnowledgeService
 
@@ -276,6 +278,10 @@ class EnhancedChatbotService:
                 "elapsed_time": elapsed_time,
             }
 
+        except ServiceOverloadedError:
+            # Let the API layer degrade and advise a retry
+            self._telemetry("enhanced_chat_overloaded", {"user_id": user_id})
+            raise
         except Exception as e:
             logger.exception(f"Enhanced chat processing failed: {e}")
             self._telemetry("enhanced_chat_error", {"error": str(e)})
@@ -330,6 +336,8 @@ class EnhancedChatbotService:
 
             return search_result
 
+        except ServiceOverloadedError:
+            raise
         except Exception as e:
             logger.exception(f"Enhanced RAG retrieval failed: {e}")
             self._telemetry("enhanced_rag_error", {"error": str(e)})
@@ -483,6 +491,8 @@ class EnhancedChatbotService:
                                 "context_used": bool(context),
                             }
                         )
+                    except ServiceOverloadedError:
+                        raise
                     except Exception as gen_error:
                         logger.warning(
                             f"LLM generation failed, using template: {gen_error}"
@@ -501,6 +511,8 @@ class EnhancedChatbotService:
 
             return answer, generation_used, response_metadata
 
+        except ServiceOverloadedError:
+            raise
         except Exception as e:
             logger.exception(f"Enhanced response generation failed: {e}")
 
@@ -14,6 +14,8 @@ from typing import List, Optional, Dict, Any
 from dataclasses import dataclass
 from concurrent.futures import ThreadPoolExecutor
 
+from app.core.overload import ServiceOverloadedError
+
 # Embedding model dependencies
 try:
     from sentence_transformers import SentenceTransformer
@@ -67,6 +69,11 @@ class EmbeddingConfig:
     # Timeout settings
     query_timeout_seconds: float = float(os.getenv("EMBEDDING_QUERY_TIMEOUT", "10.0"))
     batch_timeout_seconds: float = float(os.getenv("EMBEDDING_BATCH_TIMEOUT", "120.0"))
+    # Queries beyond this many in flight are rejected as overloaded
+    max_pending_queries: int = int(os.getenv("EMBEDDING_MAX_PENDING", "8"))
+    overload_retry_after_seconds: int = int(
+        os.getenv("EMBEDDING_RETRY_AFTER", "10")
+    )
 
     enable_postgresql: bool = (
         os.getenv("EMBEDDING_ENABLE_POSTGRESQL", "false").lower() == "true"
@@ -96,6 +103,7 @@ class EmbeddingService:
         self._query_count = 0
         self._total_query_time = 0.0
         self._memory_cleanup_count = 0
+        self._pending_queries = 0
 
         # Memory monitoring
         self._process = psutil.Process()
@@ -118,10 +126,27 @@ class EmbeddingService:
         Raises:
             TimeoutError: If embedding takes longer than configured timeout
             RuntimeError: If model loading fails
+            ServiceOverloadedError: If too many queries are already pending
         """
         if not text or not text.strip():
             raise ValueError("Text cannot be empty")
 
+        if self._pending_queries >= self.config.max_pending_queries:
+            logger.warning(
+                f"Embedding rejected: {self._pending_queries} queries pending"
+            )
+            raise ServiceOverloadedError(
+                "embedding", self.config.overload_retry_after_seconds
+            )
+
+        self._pending_queries += 1
+        try:
+            return await self._embed_query_admitted(text)
+        finally:
+            self._pending_queries -= 1
+
+    async def _embed_query_admitted(self, text: str) -> List[float]:
+        """Embed a query that has been admitted past the overload check"""
         # Memory check before processing
         await self._check_and_cleanup_memory()
 
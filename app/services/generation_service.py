@@ -19,6 +19,8 @@ from transformers import (
     GenerationConfig as HFGenerationConfig,
 )
 
+from app.core.overload import ServiceOverloadedError
+
 logger = logging.getLogger(__name__)
 
 # Force MPS optimizations
@@ -69,6 +71,11 @@ class GenerationConfig:
     # Threading
     thread_pool_workers: int = 1
     generation_timeout_seconds: float = float(os.getenv("GENERATION_TIMEOUT", "30.0"))
+    # Requests beyond this many in flight are rejected as overloaded
+    max_pending_generations: int = int(os.getenv("GENERATION_MAX_PENDING", "4"))
+    overload_retry_after_seconds: int = int(
+        os.getenv("GENERATION_RETRY_AFTER", "15")
+    )
 
     # Preprocessing
     remove_invalid_values: bool = True  # Clean inputs
@@ -117,6 +124,7 @@ class GenerationService:
         self._first_generation = True
         self._generation_count = 0
         self._total_generation_time = 0.0
+        self._pending_generations = 0
 
         logger.info(
             f"Qwen3-1.7B Service initialized (MPS available: {self._using_mps})"
@@ -270,6 +278,30 @@ class GenerationService:
         if not prompt or not prompt.strip():
             raise ValueError("Prompt cannot be empty")
 
+        if self._pending_generations >= self.config.max_pending_generations:
+            logger.warning(
+                f"Generation rejected: {self._pending_generations} requests pending"
+            )
+            raise ServiceOverloadedError(
+                "generation", self.config.overload_retry_after_seconds
+            )
+
+        self._pending_generations += 1
+        try:
+            return await self._generate_admitted(
+                prompt, max_tokens, temperature, kwargs
+            )
+        finally:
+            self._pending_generations -= 1
+
+    async def _generate_admitted(
+        self,
+        prompt: str,
+        max_tokens: Optional[int],
+        temperature: Optional[float],
+        kwargs: Dict[str, Any],
+    ) -> str:
+        """Run a generation that has been admitted past the overload check"""
         # Ensure model is loaded
         await self.ensure_model_loaded()
 
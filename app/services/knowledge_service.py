@@ -27,6 +27,8 @@ except ImportError:
 from motor.motor_asyncio import AsyncIOMotorCollection
 from bson import ObjectId
 
+from app.core.overload import ServiceOverloadedError
+
 # Optional acceleration
 try:
     import numpy as _np
@@ -365,6 +367,8 @@ class KnowledgeService:
                         candidate_multiplier,
                         filters,
                     )
+                    for r in fallback_results:
+                        r["via_fallback"] = True
                     results.extend(fallback_results)
                     fallback_applied = True
                     meta["fallback_attempts"] += 1
@@ -383,6 +387,8 @@ class KnowledgeService:
                     fallback_results = await self._execute_exact_search(
                         query, top_k, search_kb, filters
                     )
+                    for r in fallback_results:
+                        r["via_fallback"] = True
                     results.extend(fallback_results)
                     fallback_applied = True
                     meta["fallback_attempts"] += 1
@@ -393,14 +399,20 @@ class KnowledgeService:
                     query, top_k, search_docs, search_kb, candidate_multiplier, filters
                 )
 
-            # Remove duplicates and re-rank
-            results = self._deduplicate_and_rerank(results, top_k)
+            # Remove duplicates and re-rank, keeping the size of the candidate
+            # pool (a lower bound on matches, not a full count)
+            results = self._deduplicate_and_rerank(results, top_k=None)
+            meta["candidate_count"] = len(results)
+            results = results[:top_k]
 
             logger.debug(f"Search router returning {len(results)} results")
 
             # Assess search quality (enhanced feature)
             search_quality = _assess_search_quality(results, query)
 
+        except ServiceOverloadedError:
+            # Let the API layer degrade and advise a retry
+            raise
         except Exception as e:
             logger.exception(f"Unified search failed: {e}")
             meta["error"] = str(e)
@@ -501,6 +513,8 @@ class KnowledgeService:
                         results, key=lambda r: r.get("score", 0.0), reverse=True
                     )[:top_k]
 
+            except ServiceOverloadedError:
+                raise
             except Exception as e:
                 logger.warning(
                     f"Atlas Vector Search failed, falling back to hybrid: {e}"
@@ -974,6 +988,7 @@ class KnowledgeService:
 
             cos = _cosine_similarity(query_embedding, emb)
             item = dict(c)
+            item["text_score"] = float(c.get("score", 0.0))
             item["score"] = float(cos)
             item["metric"] = "cosine"
             # Remove embedding from final result to save space
@@ -1046,6 +1061,7 @@ class KnowledgeService:
                         "question": d.get("question"),
                         "answer": d.get("answer"),
                         "score": float(cos),
+                        "text_score": float(d.get("score", 0.0)),
                         "metric": "cosine",
                     },
                 )
@@ -1065,9 +1081,9 @@ class KnowledgeService:
         return avg_score < self.config.min_semantic_score
 
     def _deduplicate_and_rerank(
-        self, results: List[Dict[str, Any]], top_k: int
+        self, results: List[Dict[str, Any]], top_k: Optional[int]
     ) -> List[Dict[str, Any]]:
-        """Remove duplicates and re-rank results"""
+        """Remove duplicates and re-rank results (top_k=None keeps all)"""
         seen_ids = set()
         seen_content = set()
         unique_results = []
@@ -1087,7 +1103,7 @@ class KnowledgeService:
             seen_content.add(content)
             unique_results.append(result)
 
-            if len(unique_results) >= top_k:
+            if top_k is not None and len(unique_results) >= top_k:
                 break
 
         return unique_results
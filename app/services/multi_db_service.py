@@ -224,9 +224,10 @@ class MultiDatabaseService:
 
     async def _check_background_task_quota(self, user: User) -> None:
         """Check if user can start background tasks"""
-        quota_info = await self.billing_service.check_user_quota(
-            user, "background_tasks"
-        )
+        async with postgres_manager.get_session() as session:
+            quota_info = await self.billing_service.check_user_quota(
+                user, "background_tasks", session
+            )
 
         if not quota_info["has_quota"]:
             raise PermissionError(
@@ -449,6 +450,7 @@ async def scylla_exact_search(query: str, top_k: int = 5) -> List[Dict[str, Any]
                                 "question": row["question"],
                                 "answer": row["answer"],
                                 "score": 1.0,  # repo-defined exact hits assumed
+                                "metric": "exact_match",
                             }
                         )
                     if out:
@@ -477,6 +479,7 @@ async def scylla_exact_search(query: str, top_k: int = 5) -> List[Dict[str, Any]
                         "question": r.get("question"),
                         "answer": r.get("answer"),
                         "score": float(score),
+                        "metric": "keyword_overlap",
                     },
                 )
             )
@@ -1,5 +1,6 @@
 """Timeout-based processor that automatically moves long-running tasks to background."""
 
+import asyncio
 import time
 import logging
 from typing import Callable, Any, Optional, Dict, TYPE_CHECKING
@@ -7,7 +8,11 @@ from dataclasses import dataclass
 from concurrent.futures import ThreadPoolExecutor
 import uuid
 
+from app.services.background_tasks import TaskQuotaExceededError
+
 if TYPE_CHECKING:
+    from sqlalchemy.ext.asyncio import AsyncSession
+    from app.database.postgres_models import User
     from app.services.background_tasks import BackgroundTaskService
 
 logger = logging.getLogger(__name__)
@@ -35,24 +40,30 @@ class TimeoutProcessor:
             max_workers=2, thread_name_prefix="timeout_proc"
         )
 
-    def process_with_timeout(
+    async def process_with_timeout(
         self,
         task_function: Callable,
         timeout_threshold: float,
         session_id: str,
-        user_id: str,
+        user: "User",
         user_message: str,
+        db_session: "AsyncSession",
         task_type: str = "analysis",
     ) -> Any:
         """
         Process a task with automatic timeout detection.
 
+        Moving a task to the background is charged to the user's background
+        task quota. When the plan has none left, TaskQuotaExceededError is
+        raised for the API layer to report.
+
         Args:
             task_function: Function to execute (should be the actual processing logic)
             timeout_threshold: Seconds before moving to background
             session_id: Current session ID
-            user_id: User identifier
+            user: User the task runs for
             user_message: Original user message
+            db_session: Session used to charge the background task quota
             task_type: Type of task ("analysis" or "research")
 
         Returns:
@@ -68,7 +79,7 @@ class TimeoutProcessor:
         self._active_tasks[task_id] = {
             "start_time": time.time(),
             "session_id": session_id,
-            "user_id": user_id,
+            "user_id": str(user.id),
             "user_message": user_message,
             "task_type": task_type,
             "threshold": timeout_threshold,
@@ -95,8 +106,8 @@ class TimeoutProcessor:
                     future.cancel()
 
                     # Move to background processing
-                    background_task_id = self._move_to_background(
-                        task_id, session_id, user_id, user_message, task_type
+                    background_task_id = await self._move_to_background(
+                        task_id, session_id, user, user_message, task_type, db_session
                     )
 
                     # Return timeout response
@@ -108,15 +119,15 @@ class TimeoutProcessor:
                         f"Task {task_id} hit absolute maximum ({elapsed:.1f}s), forcing background"
                     )
                     future.cancel()
-                    background_task_id = self._move_to_background(
-                        task_id, session_id, user_id, user_message, task_type
+                    background_task_id = await self._move_to_background(
+                        task_id, session_id, user, user_message, task_type, db_session
                     )
                     return self._create_timeout_response(
                         background_task_id, elapsed, forced=True
                     )
 
                 # Sleep briefly before next check
-                time.sleep(self.config.check_interval_seconds)
+                await asyncio.sleep(self.config.check_interval_seconds)
 
             # Task completed within threshold
             result = future.result()
@@ -129,6 +140,10 @@ class TimeoutProcessor:
 
             return result
 
+        except TaskQuotaExceededError:
+            logger.info(f"Task {task_id} not moved to background: quota exhausted")
+            self._active_tasks[task_id]["status"] = "quota_exceeded"
+            raise
         except Exception as e:
             logger.error(f"Error in timeout processor for task {task_id}: {e}")
             self._active_tasks[task_id]["status"] = "error"
@@ -138,13 +153,14 @@ class TimeoutProcessor:
             # Cleanup task tracking
             self._active_tasks.pop(task_id, None)
 
-    def _move_to_background(
+    async def _move_to_background(
         self,
         task_id: str,
         session_id: str,
-        user_id: str,
+        user: "User",
         user_message: str,
         task_type: str,
+        db_session: "AsyncSession",
     ) -> str:
         """
         Move a task to background processing.
@@ -156,14 +172,16 @@ class TimeoutProcessor:
             if task_type == "analysis":
                 # Extract analysis description from user message
                 description = self._extract_analysis_description(user_message)
-                background_task_id = self.background_service.submit_data_analysis_task(
-                    user_id=user_id, data_description=description, session_id=session_id
+                background_task_id = (
+                    await self.background_service.submit_data_analysis_task(
+                        user, description, session_id, db_session
+                    )
                 )
             else:  # research
                 # Extract research topic from user message
                 topic = self._extract_research_topic(user_message)
-                background_task_id = self.background_service.submit_research_task(
-                    user_id=user_id, research_topic=topic, session_id=session_id
+                background_task_id = await self.background_service.submit_research_task(
+                    user, topic, session_id, db_session
                 )
 
             logger.info(
@@ -171,6 +189,8 @@ class TimeoutProcessor:
             )
             return background_task_id
 
+        except TaskQuotaExceededError:
+            raise
         except Exception as e:
             logger.error(f"Failed to move task {task_id} to background: {e}")
             return f"timeout_{task_id}"  # Fallback ID
@@ -1,8 +1,9 @@
 import uuid
+from datetime import datetime
 from typing import Optional, Dict, Any, List, TYPE_CHECKING
 import logging
 
-from sqlalchemy import select, update, func
+from sqlalchemy import select, update, func, and_, or_, true
 from sqlalchemy.ext.asyncio import AsyncSession
 
 from app.database.postgres_connection import postgres_manager
@@ -13,6 +14,9 @@ if TYPE_CHECKING:
 
 logger = logging.getLogger(__name__)
 
+# Where audit writers record why protected data was accessed
+AUDIT_PURPOSE_PATH = ("context", "access_reason")
+
 
 class UserService:
     """User management and profile service."""
@@ -211,6 +215,120 @@ class UserService:
             logger.error(f"Failed to search users with query '{query}': {e}")
             return []
 
+    async def get_audit_logs(
+        self,
+        session: AsyncSession,
+        requested_by: User,
+        user_id: Optional[uuid.UUID] = None,
+        action: Optional[str] = None,
+        resource_type: Optional[str] = None,
+        start_time: Optional[datetime] = None,
+        end_time: Optional[datetime] = None,
+        meta_key: Optional[str] = None,
+        meta_value: Optional[str] = None,
+        purpose: Optional[str] = None,
+        limit: int = 50,
+        offset: int = 0,
+    ) -> Dict[str, Any]:
+        """
+        Query audit logs with filters and pagination, auditing the access itself.
+
+        meta_key is a dotted path (e.g. "context.access_reason") matched against
+        the entry's new_values or old_values; with meta_value the value at that
+        path must equal it as text, otherwise the path only has to exist.
+        purpose matches the access purpose recorded at context.access_reason.
+        """
+        filters = {
+            "user_id": str(user_id) if user_id else None,
+            "action": action,
+            "resource_type": resource_type,
+            "start_time": start_time.isoformat() if start_time else None,
+            "end_time": end_time.isoformat() if end_time else None,
+            "meta_key": meta_key,
+            "meta_value": meta_value,
+            "purpose": purpose,
+        }
+
+        conditions = []
+        if user_id:
+            conditions.append(AuditLog.user_id == user_id)
+        if action:
+            conditions.append(AuditLog.action == action)
+        if resource_type:
+            conditions.append(AuditLog.resource_type == resource_type)
+        if start_time:
+            conditions.append(AuditLog.created_at >= start_time)
+        if end_time:
+            conditions.append(AuditLog.created_at <= end_time)
+        if meta_key:
+            path = tuple(meta_key.split("."))
+            matches = []
+            for column in (AuditLog.new_values, AuditLog.old_values):
+                if meta_value is None:
+                    matches.append(column[path].isnot(None))
+                else:
+                    matches.append(column[path].astext == meta_value)
+            conditions.append(or_(*matches))
+        if purpose:
+            conditions.append(
+                AuditLog.new_values[AUDIT_PURPOSE_PATH].astext == purpose
+            )
+
+        where_clause = and_(*conditions) if conditions else true()
+
+        total_result = await session.execute(
+            select(func.count(AuditLog.id)).where(where_clause)
+        )
+        total = total_result.scalar() or 0
+
+        # Order by id as well so pages stay stable when timestamps tie
+        result = await session.execute(
+            select(AuditLog)
+            .where(where_clause)
+            .order_by(AuditLog.created_at.desc(), AuditLog.id.desc())
+            .limit(limit)
+            .offset(offset)
+        )
+        logs = result.scalars().all()
+
+        # Audit logs can reference sensitive activity, so record who viewed them
+        await self._log_audit(
+            session,
+            requested_by.id,
+            "audit_logs_viewed",
+            "audit_log",
+            new_values={
+                "filters": {k: v for k, v in filters.items() if v is not None},
+                "limit": limit,
+                "offset": offset,
+                "returned": len(logs),
+            },
+        )
+        await session.commit()
+
+        return {
+            "total": total,
+            "items": [
+                {
+                    "id": str(log.id),
+                    "user_id": str(log.user_id) if log.user_id else None,
+                    "action": log.action,
+                    "resource_type": log.resource_type,
+                    "resource_id": log.resource_id,
+                    "old_values": log.old_values,
+                    "new_values": log.new_values,
+                    "ip_address": log.ip_address,
+                    "user_agent": log.user_agent,
+                    "created_at": log.created_at.isoformat()
+                    if log.created_at
+                    else None,
+                }
+                for log in logs
+            ],
+            "limit": limit,
+            "offset": offset,
+        }
+
     async def _log_audit(
         self,
         session: AsyncSession,
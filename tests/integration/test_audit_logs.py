@@ -0,0 +1,190 @@
+"""Audit log query tests - filtering, pagination and access auditing"""
+import pytest
+from uuid import uuid4
+from sqlalchemy import select
+from app.dependencies import get_auth_service, get_user_service
+from app.database.postgres_models import AuditLog
+
+
+@pytest.mark.asyncio
+class TestAuditLogQueries:
+    """Test admin audit log queries."""
+
+    @pytest.fixture
+    async def seeded_user(self, test_db_session):
+        """Create a user with a known set of audit entries."""
+        auth_service = get_auth_service()
+        user = await auth_service.create_user(
+            email=f"audit_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+
+        for i in range(5):
+            test_db_session.add(
+                AuditLog(
+                    user_id=user.id,
+                    action="record_viewed",
+                    resource_type="document",
+                    resource_id=f"doc-{i}",
+                )
+            )
+        test_db_session.add(
+            AuditLog(
+                user_id=user.id,
+                action="record_viewed",
+                resource_type="document",
+                new_values={"context": {"access_reason": "treatment"}},
+            )
+        )
+        test_db_session.add(
+            AuditLog(
+                user_id=user.id,
+                action="record_deleted",
+                resource_type="document",
+                old_values={"patient_id": "patient-42"},
+            )
+        )
+        test_db_session.add(
+            AuditLog(
+                user_id=user.id,
+                action="record_exported",
+                resource_type="document",
+                new_values={
+                    "patient_id": "patient-7",
+                    "context": {"access_reason": "emergency"},
+                },
+            )
+        )
+        await test_db_session.commit()
+        return user
+
+    async def test_filter_by_action(self, test_db_session, seeded_user):
+        """Only entries with the requested action are returned."""
+        result = await get_user_service().get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            action="record_deleted",
+        )
+
+        assert result["total"] == 1
+        assert [item["action"] for item in result["items"]] == ["record_deleted"]
+
+    async def test_pagination_boundaries(self, test_db_session, seeded_user):
+        """Pages cover every matching entry exactly once."""
+        service = get_user_service()
+        seen = []
+        for offset in (0, 2, 4):
+            page = await service.get_audit_logs(
+                test_db_session,
+                requested_by=seeded_user,
+                user_id=seeded_user.id,
+                action="record_viewed",
+                limit=2,
+                offset=offset,
+            )
+            assert page["total"] == 6
+            seen.extend(item["id"] for item in page["items"])
+
+        assert len(seen) == 6
+        assert len(set(seen)) == 6
+
+        past_end = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            action="record_viewed",
+            limit=2,
+            offset=6,
+        )
+        assert past_end["items"] == []
+
+    async def test_query_is_itself_audited(self, test_db_session, seeded_user):
+        """Viewing audit logs leaves an audit_logs_viewed entry."""
+        await get_user_service().get_audit_logs(
+            test_db_session, requested_by=seeded_user, action="record_deleted"
+        )
+
+        result = await test_db_session.execute(
+            select(AuditLog).where(
+                AuditLog.user_id == seeded_user.id,
+                AuditLog.action == "audit_logs_viewed",
+            )
+        )
+        viewed = result.scalars().all()
+        assert len(viewed) == 1
+        assert viewed[0].new_values["filters"] == {"action": "record_deleted"}
+
+    async def test_filter_by_metadata_field(self, test_db_session, seeded_user):
+        """meta_key/meta_value match top-level metadata in new or old values."""
+        service = get_user_service()
+
+        result = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            meta_key="patient_id",
+            meta_value="patient-42",
+        )
+        assert [item["action"] for item in result["items"]] == ["record_deleted"]
+
+        any_patient = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            meta_key="patient_id",
+        )
+        assert any_patient["total"] == 2
+
+    async def test_filter_by_nested_context_field(self, test_db_session, seeded_user):
+        """Dotted meta_key paths reach into nested context maps."""
+        service = get_user_service()
+
+        result = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            meta_key="context.access_reason",
+            meta_value="emergency",
+        )
+        assert [item["action"] for item in result["items"]] == ["record_exported"]
+
+        none = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            meta_key="context.access_reason",
+            meta_value="routine",
+        )
+        assert none["total"] == 0
+
+    async def test_filter_by_purpose(self, test_db_session, seeded_user):
+        """purpose matches the recorded access reason and combines with action."""
+        service = get_user_service()
+
+        result = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            purpose="emergency",
+        )
+        assert [item["action"] for item in result["items"]] == ["record_exported"]
+
+        treatment_views = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            action="record_viewed",
+            purpose="treatment",
+        )
+        assert treatment_views["total"] == 1
+
+        mismatched = await service.get_audit_logs(
+            test_db_session,
+            requested_by=seeded_user,
+            user_id=seeded_user.id,
+            action="record_viewed",
+            purpose="emergency",
+        )
+        assert mismatched["total"] == 0
@@ -0,0 +1,84 @@
+"""Per-plan background task quota enforced at submission"""
+from concurrent.futures import Future
+from unittest.mock import MagicMock
+from uuid import uuid4
+
+import pytest
+
+from app.dependencies import get_auth_service
+from app.services import background_tasks
+from app.services.background_tasks import (
+    BackgroundTaskService,
+    TaskQuotaExceededError,
+)
+from app.services.billing_service import EnhancedBillingService
+
+
+@pytest.fixture
+def task_service(monkeypatch):
+    # Keep the Redis-backed models and the simulated workers out of the way
+    monkeypatch.setattr(background_tasks, "NotificationModel", MagicMock)
+    monkeypatch.setattr(background_tasks, "AnalyticsModel", MagicMock)
+    svc = BackgroundTaskService(billing_service=EnhancedBillingService())
+    submitted = []
+
+    def fake_submit(task_type, fn, *args):
+        submitted.append(task_type)
+        future = Future()
+        future.set_result(None)
+        return future
+
+    monkeypatch.setattr(svc, "_submit", fake_submit)
+    svc.submitted = submitted
+    yield svc
+    svc.shutdown()
+
+
+async def _create_user(session, plan):
+    return await get_auth_service().create_user(
+        email=f"tasks_{plan}_{uuid4().hex[:8]}@example.com",
+        password="SecurePass123!",
+        session=session,
+        subscription_plan=plan,
+    )
+
+
+@pytest.mark.asyncio
+class TestBackgroundTaskQuota:
+    async def test_free_user_hits_task_limit(self, task_service, test_db_session):
+        user = await _create_user(test_db_session, "free")
+
+        for _ in range(5):
+            await task_service.submit_research_task(
+                user, "vector databases", "s-1", test_db_session
+            )
+
+        # Both submit paths draw on the same quota, so neither gets through
+        with pytest.raises(TaskQuotaExceededError) as exc_info:
+            await task_service.submit_research_task(
+                user, "vector databases", "s-1", test_db_session
+            )
+        with pytest.raises(TaskQuotaExceededError):
+            await task_service.submit_data_analysis_task(
+                user, "sales figures", "s-1", test_db_session
+            )
+
+        assert exc_info.value.max_allowed == 5
+        # The rejected tasks never reached a worker
+        assert len(task_service.submitted) == 5
+
+    async def test_enterprise_user_is_not_limited(
+        self, task_service, test_db_session
+    ):
+        user = await _create_user(test_db_session, "enterprise")
+
+        for _ in range(6):
+            await task_service.submit_data_analysis_task(
+                user, "sales figures", "s-1", test_db_session
+            )
+
+        assert task_service.submitted == ["data_analysis"] * 6
+        quota = await task_service.billing_service.check_user_quota(
+            user, "background_tasks", test_db_session
+        )
+        assert quota["current_usage"] == 6
@@ -1,6 +1,22 @@
 """Fixed advanced billing tests - properly uses getter and session parameters"""
+import asyncio
 import pytest
-from app.dependencies import get_billing_service
+from datetime import datetime, timedelta, timezone
+from uuid import uuid4
+from sqlalchemy import select
+from sqlalchemy.ext.asyncio import AsyncSession, async_sessionmaker
+from app.database.postgres_models import (
+    AuditLog,
+    QuotaOverride,
+    Subscription,
+    UsageRecord,
+)
+from app.dependencies import get_billing_service, get_auth_service
+from app.services.billing_service import (
+    RESET_ROLLOVER,
+    EnhancedBillingService,
+    ResetPolicy,
+)
 
 
 @pytest.mark.asyncio
@@ -30,4 +46,563 @@ class TestAdvancedBilling:
         plans = billing_service.get_available_plans()
         assert "plans" in plans
         assert "currency" in plans
-        assert plans["currency"] == "USD"
\ No newline at end of file
+        assert plans["currency"] == "USD"
+
+    async def test_plan_change_is_grandfathered(self, test_db_session):
+        """Existing subscribers keep their terms until explicitly migrated."""
+        # Fresh instance so the plan changes below don't leak into other tests
+        billing_service = EnhancedBillingService()
+        auth_service = get_auth_service()
+
+        existing = await auth_service.create_user(
+            email=f"grandfathered_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+            subscription_plan="pro",
+        )
+        original = await billing_service.create_subscription(
+            existing, "pro", "monthly", test_db_session
+        )
+        assert original.amount_cents == 2900
+        assert original.plan_version == billing_service._get_plan_version("pro")
+        original_version = original.plan_version
+
+        # Raise the price and quota of the pro plan
+        billing_service._plan_definitions["pro"]["pricing"]["monthly"] = 3900
+        billing_service._plan_definitions["pro"]["limits"]["messages"] = 2000
+
+        quota = await billing_service.check_user_quota(
+            existing, "messages", test_db_session
+        )
+        assert quota["max_allowed"] == 1000
+
+        # Read the stored row back rather than trusting the in-memory object
+        stored = (
+            await test_db_session.execute(
+                select(Subscription)
+                .where(Subscription.id == original.id)
+                .execution_options(populate_existing=True)
+            )
+        ).scalar_one()
+        assert stored.amount_cents == 2900
+        assert stored.limits["messages"] == 1000
+        assert stored.plan_version == original_version
+
+        newcomer = await auth_service.create_user(
+            email=f"newcomer_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+            subscription_plan="pro",
+        )
+        fresh = await billing_service.create_subscription(
+            newcomer, "pro", "monthly", test_db_session
+        )
+        assert fresh.amount_cents == 3900
+        assert fresh.limits["messages"] == 2000
+        assert fresh.plan_version != original_version
+
+        migrated = await billing_service.migrate_subscription_to_current_plan(
+            existing, test_db_session
+        )
+        assert migrated.amount_cents == 3900
+        assert migrated.limits["messages"] == 2000
+        assert migrated.plan_version == fresh.plan_version
+
+        # Migrating a subscription already on the current terms changes nothing
+        await billing_service.migrate_subscription_to_current_plan(
+            existing, test_db_session
+        )
+        migrations = await test_db_session.execute(
+            select(AuditLog).where(
+                AuditLog.action == "subscription_plan_migrated",
+                AuditLog.resource_id == str(original.id),
+            )
+        )
+        entry = migrations.scalar_one()
+        assert entry.old_values["plan_version"] == original_version
+        assert entry.new_values["plan_version"] == fresh.plan_version
+
+    async def test_concurrent_reservations_never_overshoot(self, test_engine):
+        """Concurrent reservations grant exactly the remaining quota."""
+        billing_service = EnhancedBillingService()
+        session_maker = async_sessionmaker(
+            test_engine, class_=AsyncSession, expire_on_commit=False
+        )
+
+        async with session_maker() as session:
+            user = await get_auth_service().create_user(
+                email=f"concurrent_{uuid4().hex[:8]}@example.com",
+                password="SecurePass123!",
+                session=session,
+            )
+
+        async def reserve():
+            async with session_maker() as session:
+                return await billing_service.reserve_usage(user, "messages", session)
+
+        results = await asyncio.gather(*(reserve() for _ in range(25)))
+
+        granted = [r for r in results if r["reserved"]]
+        assert len(granted) == 10
+        assert all(r["current_usage"] <= 10 for r in results)
+
+        async with session_maker() as session:
+            quota = await billing_service.check_user_quota(user, "messages", session)
+        assert quota["current_usage"] == 10
+        assert quota["has_quota"] is False
+
+    async def test_release_usage_restores_counter(self, test_db_session):
+        """Releasing a failed operation restores the prior usage counter."""
+        billing_service = EnhancedBillingService()
+        user = await get_auth_service().create_user(
+            email=f"release_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+
+        before = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        reservation = await billing_service.reserve_usage(
+            user, "messages", test_db_session, operation_id="op-failed-chat"
+        )
+        assert reservation["current_usage"] == before["current_usage"] + 1
+
+        released = await billing_service.release_usage(
+            user, "messages", "op-failed-chat", test_db_session, reason="chat_failed"
+        )
+        assert released["released"] is True
+        assert released["quantity_released"] == 1
+
+        after = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert after["current_usage"] == before["current_usage"]
+
+        # A second release of the same operation is a no-op
+        again = await billing_service.release_usage(
+            user, "messages", "op-failed-chat", test_db_session
+        )
+        assert again["released"] is False
+        assert again["already_released"] is True
+
+        final = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert final["current_usage"] == before["current_usage"]
+
+    async def test_release_unknown_operation_is_ignored(self, test_db_session):
+        """Releasing an operation that never recorded usage changes nothing."""
+        billing_service = EnhancedBillingService()
+        user = await get_auth_service().create_user(
+            email=f"release_unknown_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+
+        released = await billing_service.release_usage(
+            user, "messages", "op-never-recorded", test_db_session
+        )
+        assert released["released"] is False
+
+        quota = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert quota["current_usage"] == 0
+
+    async def test_quota_override_raises_limit_until_expiry(self, test_db_session):
+        """An active override supersedes the plan limit; an expired one doesn't."""
+        billing_service = EnhancedBillingService()
+        auth_service = get_auth_service()
+        user = await auth_service.create_user(
+            email=f"override_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+        admin = await auth_service.create_user(
+            email=f"support_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+
+        quota = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert quota["max_allowed"] == 10
+
+        override = await billing_service.grant_quota_override(
+            user,
+            "messages",
+            50,
+            datetime.now(timezone.utc) + timedelta(hours=2),
+            "Demo ran over the free limit",
+            granted_by=admin,
+            session=test_db_session,
+        )
+
+        quota = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert quota["max_allowed"] == 50
+        assert quota["override_expires_at"] == override.expires_at.isoformat()
+
+        reservation = await billing_service.reserve_usage(
+            user, "messages", test_db_session
+        )
+        assert reservation["max_allowed"] == 50
+
+        # Let the override lapse
+        override.expires_at = datetime.now(timezone.utc) - timedelta(minutes=1)
+        await test_db_session.commit()
+        await billing_service.cache.invalidate_user_cache(str(user.id))
+
+        quota = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert quota["max_allowed"] == 10
+        assert quota["override_expires_at"] is None
+
+        audit = await test_db_session.execute(
+            select(AuditLog).where(
+                AuditLog.action == "quota_override_granted",
+                AuditLog.resource_id == str(override.id),
+            )
+        )
+        assert audit.scalar_one().new_values["reason"] == "Demo ran over the free limit"
+
+    async def test_anomaly_throttle_beats_admin_grant(self, test_db_session):
+        """A larger admin grant can't lift an active anomaly throttle."""
+        billing_service = EnhancedBillingService()
+        auth_service = get_auth_service()
+        user = await auth_service.create_user(
+            email=f"throttled_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+        admin = await auth_service.create_user(
+            email=f"support_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+
+        test_db_session.add(
+            QuotaOverride(
+                user_id=user.id,
+                resource_type="messages",
+                max_allowed=4,
+                kind="throttle",
+                expires_at=datetime.now(timezone.utc) + timedelta(hours=24),
+                reason="Automatic throttle after usage anomaly",
+            )
+        )
+        await test_db_session.commit()
+
+        grant = await billing_service.grant_quota_override(
+            user,
+            "messages",
+            500,
+            datetime.now(timezone.utc) + timedelta(hours=2),
+            "Customer asked for more headroom",
+            granted_by=admin,
+            session=test_db_session,
+        )
+        assert grant.kind == "grant"
+
+        quota = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert quota["max_allowed"] == 4
+
+    async def test_inactive_subscription_flagged_then_downgraded(
+        self, test_db_session
+    ):
+        """Unused paid plans are flagged, then downgraded one tier after grace."""
+        billing_service = EnhancedBillingService()
+        auth_service = get_auth_service()
+
+        idle = await auth_service.create_user(
+            email=f"idle_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+            subscription_plan="enterprise",
+        )
+        busy = await auth_service.create_user(
+            email=f"busy_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+            subscription_plan="pro",
+        )
+        idle_sub = await billing_service.create_subscription(
+            idle, "enterprise", "monthly", test_db_session
+        )
+        busy_sub = await billing_service.create_subscription(
+            busy, "pro", "monthly", test_db_session
+        )
+
+        long_ago = datetime.now(timezone.utc) - timedelta(days=120)
+        idle_sub.started_at = long_ago
+        busy_sub.started_at = long_ago
+        await test_db_session.commit()
+        await billing_service.record_usage(busy, "messages", test_db_session)
+
+        summary = await billing_service.process_inactive_subscriptions(
+            test_db_session, inactive_days=90, grace_days=14
+        )
+        flagged = {entry["subscription_id"] for entry in summary["flagged"]}
+        assert str(idle_sub.id) in flagged
+        assert str(busy_sub.id) not in flagged
+
+        # Still inside the grace window: nothing changes yet
+        summary = await billing_service.process_inactive_subscriptions(
+            test_db_session,
+            inactive_days=90,
+            grace_days=14,
+            now=datetime.now(timezone.utc) + timedelta(days=7),
+        )
+        pending = {entry["subscription_id"] for entry in summary["pending"]}
+        assert str(idle_sub.id) in pending
+
+        summary = await billing_service.process_inactive_subscriptions(
+            test_db_session,
+            inactive_days=90,
+            grace_days=14,
+            now=datetime.now(timezone.utc) + timedelta(days=15),
+        )
+        downgraded = {
+            entry["subscription_id"]: entry["new_plan_type"]
+            for entry in summary["downgraded"]
+        }
+        assert downgraded[str(idle_sub.id)] == "pro"
+        assert str(busy_sub.id) not in downgraded
+
+        await test_db_session.refresh(idle_sub)
+        await test_db_session.refresh(busy_sub)
+        await test_db_session.refresh(idle)
+        assert idle_sub.status == "active"
+        assert idle_sub.plan_type == "pro"
+        assert idle_sub.amount_cents == 2900
+        assert idle.subscription_plan == "pro"
+        assert busy_sub.plan_type == "pro"
+
+        audit = await test_db_session.execute(
+            select(AuditLog).where(
+                AuditLog.action == "subscription_auto_downgraded",
+                AuditLog.resource_id == str(idle_sub.id),
+            )
+        )
+        entry = audit.scalar_one()
+        assert entry.old_values["plan_type"] == "enterprise"
+        assert entry.new_values["plan_type"] == "pro"
+
+    async def test_usage_spike_is_flagged_but_steady_growth_is_not(
+        self, test_db_session
+    ):
+        """Only usage far above a user's own baseline rate is flagged."""
+        billing_service = EnhancedBillingService()
+        auth_service = get_auth_service()
+
+        async def add_usage(user, month, quantity):
+            start, end = billing_service._current_billing_period(
+                datetime(2030, month, 10, tzinfo=timezone.utc)
+            )
+            test_db_session.add(
+                UsageRecord(
+                    user_id=user.id,
+                    resource_type="api_calls",
+                    quantity=quantity,
+                    billing_period_start=start,
+                    billing_period_end=end,
+                    extra_data={},
+                )
+            )
+
+        spiking = await auth_service.create_user(
+            email=f"spike_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+        steady = await auth_service.create_user(
+            email=f"steady_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+        # Three months of history, then the current period (June)
+        for month, steady_qty in [(3, 300), (4, 310), (5, 320)]:
+            await add_usage(spiking, month, 30)
+            await add_usage(steady, month, steady_qty)
+        await add_usage(spiking, 6, 300)
+        await add_usage(steady, 6, 170)
+        await test_db_session.commit()
+
+        now = datetime(2030, 6, 16, 12, tzinfo=timezone.utc)
+        summary = await billing_service.detect_usage_anomalies(
+            test_db_session, spike_multiplier=3.0, throttle_hours=24, now=now
+        )
+        flagged = {entry["user_id"] for entry in summary["flagged"]}
+        assert str(spiking.id) in flagged
+        assert str(steady.id) not in flagged
+
+        audit = await test_db_session.execute(
+            select(AuditLog).where(
+                AuditLog.user_id == spiking.id,
+                AuditLog.action == "usage_anomaly_flagged",
+            )
+        )
+        assert audit.scalar_one().new_values["current_usage"] == 300
+
+        throttle = (
+            await test_db_session.execute(
+                select(QuotaOverride).where(QuotaOverride.user_id == spiking.id)
+            )
+        ).scalar_one()
+        assert throttle.max_allowed == 300
+        assert throttle.kind == "throttle"
+
+        # A user is flagged at most once per period
+        summary = await billing_service.detect_usage_anomalies(
+            test_db_session, spike_multiplier=3.0, now=now
+        )
+        assert str(spiking.id) not in {e["user_id"] for e in summary["flagged"]}
+
+    async def test_standing_spike_throttles_at_all_time_total(
+        self, test_db_session, monkeypatch
+    ):
+        """Standing throttles hold the all-time total; notices follow the commit."""
+        billing_service = EnhancedBillingService()
+        sent = []
+        monkeypatch.setattr(
+            billing_service.notifications,
+            "add_notification",
+            lambda user_id, data: sent.append(user_id),
+        )
+
+        user = await get_auth_service().create_user(
+            email=f"storage_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+        # Old uploads outside the baseline window still count for storage
+        for month, quantity in [(1, 1000), (3, 30), (4, 30), (5, 30), (6, 300)]:
+            start, end = billing_service._current_billing_period(
+                datetime(2030, month, 10, tzinfo=timezone.utc)
+            )
+            test_db_session.add(
+                UsageRecord(
+                    user_id=user.id,
+                    resource_type="storage_mb",
+                    quantity=quantity,
+                    billing_period_start=start,
+                    billing_period_end=end,
+                    extra_data={},
+                )
+            )
+        await test_db_session.commit()
+
+        now = datetime(2030, 6, 16, 12, tzinfo=timezone.utc)
+
+        # A failed commit must not leave users with a warning for nothing
+        async def failing_commit():
+            raise RuntimeError("commit failed")
+
+        with monkeypatch.context() as patched:
+            patched.setattr(test_db_session, "commit", failing_commit)
+            with pytest.raises(RuntimeError):
+                await billing_service.detect_usage_anomalies(
+                    test_db_session, spike_multiplier=3.0, throttle_hours=24, now=now
+                )
+        assert str(user.id) not in sent
+
+        summary = await billing_service.detect_usage_anomalies(
+            test_db_session, spike_multiplier=3.0, throttle_hours=24, now=now
+        )
+        entry = next(e for e in summary["flagged"] if e["user_id"] == str(user.id))
+        assert entry["current_usage"] == 300
+        assert entry["quota_usage"] == 1390
+        assert sent.count(str(user.id)) == 1
+
+        throttle = await test_db_session.execute(
+            select(QuotaOverride).where(QuotaOverride.user_id == user.id)
+        )
+        assert throttle.scalar_one().max_allowed == 1390
+
+    async def test_reset_policies_across_period_boundary(self, test_db_session):
+        """Periodic quotas reset each period; standing balances carry forward."""
+        billing_service = EnhancedBillingService()
+        auth_service = get_auth_service()
+
+        user = await auth_service.create_user(
+            email=f"reset_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+
+        period_start, _ = billing_service._current_billing_period()
+        previous_start, previous_end = billing_service._current_billing_period(
+            period_start - timedelta(seconds=1)
+        )
+        for resource_type, quantity in [("api_calls", 7), ("storage_mb", 40)]:
+            test_db_session.add(
+                UsageRecord(
+                    user_id=user.id,
+                    resource_type=resource_type,
+                    quantity=quantity,
+                    billing_period_start=previous_start,
+                    billing_period_end=previous_end,
+                    extra_data={},
+                )
+            )
+        await test_db_session.commit()
+        await billing_service.record_usage(
+            user, "storage_mb", test_db_session, quantity=10
+        )
+
+        api_calls = await billing_service.check_user_quota(
+            user, "api_calls", test_db_session
+        )
+        assert api_calls["reset_policy"] == "periodic"
+        assert api_calls["current_usage"] == 0
+
+        storage = await billing_service.check_user_quota(
+            user, "storage_mb", test_db_session
+        )
+        assert storage["reset_policy"] == "standing"
+        assert storage["current_usage"] == 50
+        assert storage["remaining"] == 50
+
+    async def test_rollover_carries_unused_units_up_to_cap(self, test_db_session):
+        """Unused units from last period extend this period's limit, capped."""
+        billing_service = EnhancedBillingService(
+            reset_policies={"messages": ResetPolicy(RESET_ROLLOVER, rollover_cap=5)}
+        )
+        auth_service = get_auth_service()
+
+        user = await auth_service.create_user(
+            email=f"rollover_{uuid4().hex[:8]}@example.com",
+            password="SecurePass123!",
+            session=test_db_session,
+        )
+        period_start, _ = billing_service._current_billing_period()
+        previous_start, previous_end = billing_service._current_billing_period(
+            period_start - timedelta(seconds=1)
+        )
+        user.created_at = previous_start
+        test_db_session.add(
+            UsageRecord(
+                user_id=user.id,
+                resource_type="messages",
+                quantity=1,
+                billing_period_start=previous_start,
+                billing_period_end=previous_end,
+                extra_data={},
+            )
+        )
+        await test_db_session.commit()
+
+        # 9 of the free plan's 10 messages went unused; the cap allows 5
+        quota = await billing_service.check_user_quota(
+            user, "messages", test_db_session
+        )
+        assert quota["reset_policy"] == "rollover"
+        assert quota["current_usage"] == 0
+        assert quota["carried_over"] == 5
+        assert quota["max_allowed"] == 15
@@ -2,6 +2,9 @@
 
 import pytest
 
+from app.api.main import app
+from app.config import config
+
 
 @pytest.mark.asyncio
 class TestApiEndpoints:
@@ -57,3 +60,30 @@ class TestApiEndpoints:
         endpoints = data["endpoints"]
         assert "health" in endpoints
         assert endpoints["health"] == "/health"
+
+    async def test_capabilities(self, test_client):
+        """Test capabilities discovery endpoint."""
+        response = await test_client.get("/capabilities")
+        assert response.status_code == 200
+
+        data = response.json()
+        assert data["service"] == "chatbot-api"
+        assert data["version"] == app.version
+
+        # Models are whatever the running configuration loads
+        assert data["models"] == {
+            "embedding": config.embedding.model_name,
+            "generation": config.generation.model_name,
+        }
+
+        features = data["features"]
+        assert "semantic" in features["search"]["routes"]
+        assert features["search"]["routes_by_plan"]["free"] == ["exact", "auto"]
+        assert set(features["billing"]["plans"]) == {"free", "pro", "enterprise"}
+
+        limits = data["limits"]
+        assert limits["chat_message_max_chars"] == 2000
+        assert limits["search_query_max_chars"] == 500
+        assert limits["search_top_k_max"] == 50
+        assert limits["rate_limits"]["chat"] == {"calls": 30, "period_seconds": 60}
+        assert limits["plan_quotas"]["free"]["messages"] == 10
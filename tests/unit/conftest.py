@@ -0,0 +1,35 @@
+"""Fixtures shared by the unit tests"""
+
+import pytest
+
+from app.api.endpoints import chat as chat_endpoint
+from app.api.endpoints import search as search_endpoint
+
+
+class StubQuotaChecker:
+    """Records quota calls instead of touching the billing database"""
+
+    def __init__(self):
+        self.reserved = 0
+        self.released = []
+
+    async def reserve(self, request, current_user, session):
+        self.reserved += 1
+
+    def reservation(self, request):
+        return {}
+
+    async def release(self, request, current_user, session, reason=None):
+        self.released.append(reason)
+
+    async def settle(self, request, current_user, session, succeeded, reason=None):
+        if not succeeded:
+            self.released.append(reason)
+
+
+@pytest.fixture
+def quota(monkeypatch):
+    checker = StubQuotaChecker()
+    monkeypatch.setattr(chat_endpoint, "check_message_quota", checker)
+    monkeypatch.setattr(search_endpoint, "check_search_quota", checker)
+    return checker
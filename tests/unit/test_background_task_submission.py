@@ -0,0 +1,122 @@
+"""Background task submission is always charged to the task quota"""
+
+import time
+from types import SimpleNamespace
+
+import httpx
+import pytest
+from fastapi import FastAPI
+from httpx import ASGITransport
+
+from app.api.endpoints import tasks as tasks_endpoint
+from app.core import auth_dependencies
+from app.dependencies import get_background_task_service, get_db_session
+from app.services.background_tasks import TaskQuotaExceededError
+from app.services.timeout_processor import TimeoutProcessor
+
+USER = SimpleNamespace(id="user-1", subscription_plan="free", is_active=True)
+
+
+class StubBackgroundService:
+    """Grants a fixed number of tasks, then refuses like an exhausted plan"""
+
+    def __init__(self, allowance):
+        self.allowance = allowance
+        self.submitted = []
+
+    async def _submit(self, task_type, user):
+        if len(self.submitted) >= self.allowance:
+            raise TaskQuotaExceededError(user.subscription_plan, 5, 5)
+        self.submitted.append(task_type)
+        return f"task-{len(self.submitted)}"
+
+    async def submit_research_task(self, user, research_topic, session_id, db_session):
+        return await self._submit("research", user)
+
+    async def submit_data_analysis_task(
+        self, user, data_description, session_id, db_session
+    ):
+        return await self._submit("data_analysis", user)
+
+
+async def _no_session():
+    yield None
+
+
+async def _post_task(service, body):
+    app = FastAPI()
+    app.include_router(tasks_endpoint.router)
+    app.dependency_overrides.update(
+        {
+            auth_dependencies.get_current_active_user: lambda: USER,
+            get_background_task_service: lambda: service,
+            get_db_session: _no_session,
+        }
+    )
+    transport = ASGITransport(app=app)
+    async with httpx.AsyncClient(transport=transport, base_url="http://test") as client:
+        return await client.post("/tasks/", json=body)
+
+
+def _slow_task():
+    time.sleep(0.3)
+    return "done"
+
+
+def _processor(service):
+    processor = TimeoutProcessor(background_service=service)
+    processor.config.check_interval_seconds = 0.01
+    return processor
+
+
+@pytest.mark.asyncio
+class TestTaskSubmission:
+    async def test_submit_within_quota_is_accepted(self):
+        service = StubBackgroundService(allowance=1)
+
+        response = await _post_task(
+            service, {"task_type": "research", "description": "vector search"}
+        )
+
+        assert response.status_code == 202
+        assert response.json()["task_id"] == "task-1"
+        assert service.submitted == ["research"]
+
+    async def test_exhausted_quota_is_rejected_with_429(self):
+        service = StubBackgroundService(allowance=0)
+
+        response = await _post_task(
+            service, {"task_type": "data_analysis", "description": "sales"}
+        )
+
+        assert response.status_code == 429
+        assert response.json()["detail"]["max_allowed"] == 5
+        assert service.submitted == []
+
+    async def test_slow_task_moves_to_background_through_quota(self):
+        service = StubBackgroundService(allowance=1)
+
+        result = await _processor(service).process_with_timeout(
+            _slow_task, 0.05, "s-1", USER, "research vector search", None, "research"
+        )
+
+        assert result["background_task_id"] == "task-1"
+        assert service.submitted == ["research"]
+
+    async def test_slow_task_refused_when_quota_exhausted(self):
+        service = StubBackgroundService(allowance=0)
+
+        with pytest.raises(TaskQuotaExceededError):
+            await _processor(service).process_with_timeout(
+                _slow_task, 0.05, "s-1", USER, "analyze sales", None
+            )
+
+    async def test_fast_task_stays_in_foreground(self):
+        service = StubBackgroundService(allowance=1)
+
+        result = await _processor(service).process_with_timeout(
+            lambda: "done", 5.0, "s-1", USER, "hello", None
+        )
+
+        assert result == "done"
+        assert service.submitted == []
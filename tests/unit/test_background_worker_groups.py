@@ -0,0 +1,67 @@
+"""Background task worker group routing tests"""
+
+import threading
+import time
+from unittest.mock import MagicMock
+
+import pytest
+
+from app.services import background_tasks
+from app.services.background_tasks import BackgroundTaskService, parse_worker_groups
+
+
+@pytest.fixture
+def service(monkeypatch):
+    # Keep the Redis-backed models out of the way
+    monkeypatch.setattr(background_tasks, "NotificationModel", MagicMock)
+    monkeypatch.setattr(background_tasks, "AnalyticsModel", MagicMock)
+    svc = BackgroundTaskService(
+        worker_groups={"research": 2, "data_analysis": 1, "default": 1}
+    )
+    yield svc
+    svc.shutdown()
+
+
+def test_parse_worker_groups():
+    assert parse_worker_groups("research=2, data_analysis=1") == {
+        "research": 2,
+        "data_analysis": 1,
+        "default": 1,
+    }
+    assert parse_worker_groups("research=oops,default=3") == {"default": 3}
+
+
+def test_research_flood_does_not_delay_data_analysis(service):
+    release = threading.Event()
+
+    # Saturate the research group and leave a backlog queued behind it
+    research = [service._submit("research", release.wait, 5) for _ in range(10)]
+
+    start = time.monotonic()
+    analysis = service._submit("data_analysis", lambda: "done")
+    assert analysis.result(timeout=1) == "done"
+    assert time.monotonic() - start < 1
+
+    deadline = time.monotonic() + 1
+    while service.get_worker_stats()["research"]["running"] < 2:
+        assert time.monotonic() < deadline
+        time.sleep(0.01)
+
+    stats = service.get_worker_stats()
+    assert stats["research"]["running"] == 2
+    assert stats["research"]["queued"] == 8
+    assert stats["data_analysis"]["completed"] == 1
+
+    release.set()
+    for future in research:
+        future.result(timeout=5)
+    assert service.get_worker_stats()["research"]["completed"] == 10
+
+
+def test_unclassified_tasks_use_default_group(service):
+    future = service._submit("notification", lambda: "sent")
+    assert future.result(timeout=1) == "sent"
+
+    stats = service.get_worker_stats()
+    assert stats["default"]["completed"] == 1
+    assert "notification" not in stats
@@ -0,0 +1,82 @@
+"""Graceful degradation when AI services are overloaded"""
+
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException, Response
+
+from app.api.endpoints.chat import ChatRequest, send_chat_message
+from app.api.endpoints.search import SearchRequest, search
+from app.core.overload import ServiceOverloadedError
+
+
+class OverloadedChatbot:
+    def __init__(self, service="generation"):
+        self.service = service
+
+    async def answer_user_message(self, **kwargs):
+        raise ServiceOverloadedError(self.service, retry_after=20)
+
+
+class OverloadedKnowledgeService:
+    async def search_router(self, query, top_k, route, filters):
+        raise ServiceOverloadedError("embedding", retry_after=10)
+
+
+async def _send(chatbot):
+    user = SimpleNamespace(id="user-1", subscription_plan="pro")
+    http_request = SimpleNamespace(state=SimpleNamespace())
+    response = Response()
+    result = await send_chat_message(
+        request=ChatRequest(message="Hello", enable_rag=False, session_id="s-1"),
+        http_request=http_request,
+        response=response,
+        current_user=user,
+        _rate_limit=user,
+        chatbot=chatbot,
+        knowledge_service=None,
+        session=None,
+    )
+    return result, response
+
+
+@pytest.mark.asyncio
+async def test_overload_returns_degraded_answer(quota):
+    result, response = await _send(OverloadedChatbot())
+
+    assert result.response_type == "degraded"
+    assert result.retry_after_seconds == 20
+    assert response.headers["Retry-After"] == "20"
+    # The user isn't charged for a message nobody answered
+    assert quota.released == ["generation_overloaded"]
+    # Counts for a released reservation would be stale, so none are reported
+    assert result.usage_info == {"charged": False}
+
+
+@pytest.mark.asyncio
+async def test_embedding_overload_also_degrades(quota):
+    result, response = await _send(OverloadedChatbot(service="embedding"))
+
+    assert result.response_type == "degraded"
+    assert response.headers["Retry-After"] == "20"
+    assert quota.released == ["embedding_overloaded"]
+
+
+@pytest.mark.asyncio
+async def test_search_overload_returns_503_with_retry_after(quota):
+    user = SimpleNamespace(id="user-1", subscription_plan="pro")
+    http_request = SimpleNamespace(state=SimpleNamespace())
+
+    with pytest.raises(HTTPException) as exc_info:
+        await search(
+            SearchRequest(query="redis"),
+            http_request,
+            user,
+            user,
+            OverloadedKnowledgeService(),
+            None,
+        )
+
+    assert exc_info.value.status_code == 503
+    assert exc_info.value.headers["Retry-After"] == "10"
+    assert quota.released == ["search_failed"]
@@ -0,0 +1,120 @@
+"""Conditional GET middleware tests"""
+
+from datetime import datetime, timezone
+
+import httpx
+import pytest
+from fastapi import FastAPI, Response
+from fastapi.middleware.cors import CORSMiddleware
+from httpx import ASGITransport
+
+from app.core.http_caching import ConditionalGetMiddleware
+
+UPDATED_AT = datetime(2025, 8, 1, 9, 30, tzinfo=timezone.utc)
+
+
+def _build_app(last_modified=lambda path: UPDATED_AT) -> FastAPI:
+    app = FastAPI()
+    app.add_middleware(CORSMiddleware, allow_origins=["*"], allow_methods=["*"])
+    app.add_middleware(
+        ConditionalGetMiddleware,
+        paths=["/static"],
+        max_age=60,
+        last_modified=last_modified,
+    )
+
+    @app.get("/static")
+    async def static_payload(response: Response):
+        response.headers["Vary"] = "Accept-Language"
+        return {"plans": ["free", "pro", "enterprise"]}
+
+    @app.get("/dynamic")
+    async def dynamic_payload():
+        return {"value": 1}
+
+    return app
+
+
+@pytest.fixture
+async def client():
+    transport = ASGITransport(app=_build_app())
+    async with httpx.AsyncClient(transport=transport, base_url="http://test") as c:
+        yield c
+
+
+@pytest.mark.asyncio
+class TestConditionalGetMiddleware:
+    async def test_sets_validators_on_designated_route(self, client):
+        response = await client.get("/static")
+        assert response.status_code == 200
+        assert response.headers["etag"].startswith('"')
+        assert response.headers["last-modified"] == "Fri, 01 Aug 2025 09:30:00 GMT"
+        assert response.headers["cache-control"] == "public, max-age=60"
+        assert response.json() == {"plans": ["free", "pro", "enterprise"]}
+
+    async def test_matching_etag_yields_304(self, client):
+        first = await client.get("/static")
+        etag = first.headers["etag"]
+
+        second = await client.get("/static", headers={"If-None-Match": etag})
+        assert second.status_code == 304
+        assert second.content == b""
+        assert second.headers["etag"] == etag
+
+    async def test_304_keeps_inner_headers_but_not_body_headers(self, client):
+        origin = {"Origin": "http://app.test"}
+        first = await client.get("/static", headers=origin)
+
+        second = await client.get(
+            "/static", headers={"If-None-Match": first.headers["etag"], **origin}
+        )
+        assert second.status_code == 304
+        assert second.headers["vary"] == first.headers["vary"]
+        assert second.headers["access-control-allow-origin"] == "*"
+        assert "content-type" not in second.headers
+        assert second.headers.get("content-length", "0") == "0"
+
+    async def test_stale_etag_returns_full_body(self, client):
+        response = await client.get("/static", headers={"If-None-Match": '"stale"'})
+        assert response.status_code == 200
+        assert response.json()["plans"]
+
+    async def test_if_modified_since_yields_304(self, client):
+        first = await client.get("/static")
+        response = await client.get(
+            "/static", headers={"If-Modified-Since": first.headers["last-modified"]}
+        )
+        assert response.status_code == 304
+
+    async def test_last_modified_comes_from_the_source_not_the_process(self):
+        """Separate workers report the same date for the same data."""
+        responses = []
+        for _ in range(2):
+            transport = ASGITransport(app=_build_app())
+            async with httpx.AsyncClient(
+                transport=transport, base_url="http://test"
+            ) as c:
+                responses.append(await c.get("/static"))
+
+        assert len({r.headers["last-modified"] for r in responses}) == 1
+
+        older = "Thu, 31 Jul 2025 00:00:00 GMT"
+        transport = ASGITransport(app=_build_app())
+        async with httpx.AsyncClient(transport=transport, base_url="http://test") as c:
+            response = await c.get("/static", headers={"If-Modified-Since": older})
+        assert response.status_code == 200
+
+    async def test_no_last_modified_without_a_source(self):
+        transport = ASGITransport(app=_build_app(last_modified=None))
+        async with httpx.AsyncClient(transport=transport, base_url="http://test") as c:
+            response = await c.get(
+                "/static",
+                headers={"If-Modified-Since": "Fri, 01 Aug 2025 09:30:00 GMT"},
+            )
+        assert response.status_code == 200
+        assert "last-modified" not in response.headers
+
+    async def test_other_routes_untouched(self, client):
+        response = await client.get("/dynamic")
+        assert response.status_code == 200
+        assert "etag" not in response.headers
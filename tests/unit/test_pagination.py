@@ -0,0 +1,64 @@
+"""Shared pagination envelope tests"""
+
+from types import SimpleNamespace
+from typing import Any, Dict
+
+import pytest
+
+from app.api.endpoints.billing import get_billing_history
+from app.api.endpoints.chat import get_chat_history
+from app.core.pagination import PagedResponse
+
+
+class StubBillingService:
+    async def get_billing_history(self, user, session, limit=10, offset=0):
+        items = [{"id": str(i)} for i in range(25)]
+        return {"total": len(items), "items": items[offset : offset + limit]}
+
+
+def test_build_middle_page():
+    page = PagedResponse[int].build([3, 4], total=5, limit=2, offset=2)
+    assert page.items == [3, 4]
+    assert page.total == 5
+    assert page.has_more is True
+    assert page.next_cursor == "4"
+
+
+def test_build_last_page():
+    page = PagedResponse[int].build([5], total=5, limit=2, offset=4)
+    assert page.has_more is False
+    assert page.next_cursor is None
+
+
+@pytest.mark.asyncio
+async def test_billing_history_uses_envelope():
+    user = SimpleNamespace(id="user-1")
+    page = await get_billing_history(
+        limit=10,
+        offset=20,
+        current_user=user,
+        session=None,
+        billing=StubBillingService(),
+    )
+
+    assert isinstance(page, PagedResponse)
+    assert page.total == 25
+    assert len(page.items) == 5
+    assert (page.limit, page.offset) == (10, 20)
+    assert page.has_more is False
+
+
+@pytest.mark.asyncio
+async def test_chat_history_uses_envelope():
+    user = SimpleNamespace(id="user-1")
+    page = await get_chat_history(
+        session_id="s-1", limit=20, offset=0, current_user=user
+    )
+
+    body: Dict[str, Any] = page.model_dump()
+    assert {"items", "total", "limit", "offset", "next_cursor", "has_more"} <= set(
+        body
+    )
+    assert body["user_id"] == "user-1"
+    assert body["session_id"] == "s-1"
+    assert body["has_more"] is False
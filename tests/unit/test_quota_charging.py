@@ -0,0 +1,193 @@
+"""Quota is charged only for requests that are actually served"""
+
+from types import SimpleNamespace
+
+import httpx
+import pytest
+from fastapi import FastAPI, HTTPException, status
+from httpx import ASGITransport
+
+from app.api.endpoints import chat as chat_endpoint
+from app.api.endpoints import search as search_endpoint
+from app.core import auth_dependencies
+from app.dependencies import (
+    get_billing_service,
+    get_chatbot_service,
+    get_db_session,
+    get_knowledge_service,
+)
+
+
+class RecordingBillingService:
+    def __init__(self):
+        self.reserved = []
+        self.recorded = []
+        self.released = []
+        self.reservation_error = None
+        self.exhausted = set()
+
+    async def check_user_quota(self, user, resource_type, session):
+        return {"has_quota": True, "current_usage": 0, "max_allowed": 10}
+
+    async def reserve_usage(self, user, resource_type, session, **kwargs):
+        if self.reservation_error:
+            raise self.reservation_error
+        if resource_type in self.exhausted:
+            return {"has_quota": False, "operation_id": None}
+        self.reserved.append(resource_type)
+        return {"has_quota": True, "operation_id": kwargs.get("operation_id")}
+
+    async def record_usage(self, user, resource_type, session, **kwargs):
+        self.recorded.append(resource_type)
+
+    async def release_usage(self, user, resource_type, operation_id, session, **kwargs):
+        self.released.append(resource_type)
+
+
+class StubChatbot:
+    async def answer_user_message(self, **kwargs):
+        return {"answer": "Hi there", "tokens_used": 3}
+
+
+class ConflictingChatbot:
+    async def answer_user_message(self, **kwargs):
+        raise HTTPException(status_code=status.HTTP_409_CONFLICT, detail="Busy")
+
+
+class StubKnowledgeService:
+    async def search_router(self, query, top_k, route, filters):
+        return {"route": route, "results": [], "meta": {}}
+
+
+USER = SimpleNamespace(id="user-1", subscription_plan="pro", is_active=True)
+
+
+def _allow():
+    return USER
+
+
+def _rate_limited():
+    raise HTTPException(
+        status_code=status.HTTP_429_TOO_MANY_REQUESTS, detail="Rate limit exceeded"
+    )
+
+
+async def _no_session():
+    yield None
+
+
+@pytest.fixture
+def billing(monkeypatch):
+    service = RecordingBillingService()
+    monkeypatch.setattr(auth_dependencies, "get_billing_service", lambda: service)
+    return service
+
+
+def _app(billing, rate_limiter, chatbot=StubChatbot):
+    app = FastAPI()
+    app.include_router(chat_endpoint.router)
+    app.include_router(search_endpoint.router)
+    app.dependency_overrides.update(
+        {
+            auth_dependencies.get_current_active_user: _allow,
+            chat_endpoint.chat_rate_limiter: rate_limiter,
+            search_endpoint.search_rate_limiter: rate_limiter,
+            get_db_session: _no_session,
+            get_chatbot_service: chatbot,
+            get_knowledge_service: StubKnowledgeService,
+            get_billing_service: lambda: billing,
+        }
+    )
+    return app
+
+
+async def _post(app, path, body):
+    transport = ASGITransport(app=app)
+    async with httpx.AsyncClient(transport=transport, base_url="http://test") as client:
+        return await client.post(path, json=body)
+
+
+@pytest.mark.asyncio
+class TestQuotaCharging:
+    async def test_valid_message_is_charged_once(self, billing):
+        response = await _post(
+            _app(billing, _allow),
+            "/chat/message",
+            {"message": "Hello", "enable_rag": False},
+        )
+
+        assert response.status_code == 200
+        assert billing.reserved == ["messages"]
+
+    async def test_rag_retrieval_reserves_an_api_call(self, billing):
+        response = await _post(
+            _app(billing, _allow), "/chat/message", {"message": "Hello"}
+        )
+
+        assert response.status_code == 200
+        assert billing.reserved == ["messages", "api_calls"]
+        assert billing.recorded == []
+
+    async def test_exhausted_api_calls_release_the_message(self, billing):
+        billing.exhausted.add("api_calls")
+
+        response = await _post(
+            _app(billing, _allow), "/chat/message", {"message": "Hello"}
+        )
+
+        assert response.status_code == 429
+        assert billing.reserved == ["messages"]
+        assert billing.released == ["messages"]
+
+    async def test_rate_limited_message_is_not_charged(self, billing):
+        response = await _post(
+            _app(billing, _rate_limited), "/chat/message", {"message": "Hello"}
+        )
+
+        assert response.status_code == 429
+        assert billing.reserved == []
+        assert billing.recorded == []
+
+    async def test_invalid_message_is_not_charged(self, billing):
+        response = await _post(_app(billing, _allow), "/chat/message", {})
+
+        assert response.status_code == 422
+        assert billing.reserved == []
+        assert billing.recorded == []
+
+    async def test_rate_limited_search_is_not_charged(self, billing):
+        response = await _post(
+            _app(billing, _rate_limited), "/search/", {"query": "redis"}
+        )
+
+        assert response.status_code == 429
+        assert billing.reserved == []
+
+    async def test_invalid_search_is_not_charged(self, billing):
+        response = await _post(_app(billing, _allow), "/search/", {"query": ""})
+
+        assert response.status_code == 422
+        assert billing.reserved == []
+
+    async def test_client_error_after_reservation_is_released(self, billing):
+        response = await _post(
+            _app(billing, _allow, chatbot=ConflictingChatbot),
+            "/chat/message",
+            {"message": "Hello"},
+        )
+
+        assert response.status_code == 409
+        assert billing.reserved == ["messages", "api_calls"]
+        assert billing.released == ["messages", "api_calls"]
+
+    async def test_unreserved_success_is_recorded(self, billing):
+        # Reservation fails open, so the usage is recorded after the fact
+        billing.reservation_error = RuntimeError("database unavailable")
+
+        response = await _post(
+            _app(billing, _allow), "/chat/message", {"message": "Hello"}
+        )
+
+        assert response.status_code == 200
+        assert billing.recorded == ["messages", "api_calls"]
+        assert billing.released == []
@@ -0,0 +1,74 @@
+"""Request ID propagation tests"""
+
+import httpx
+import pytest
+from fastapi import FastAPI, HTTPException, Request
+from fastapi.exceptions import RequestValidationError
+from httpx import ASGITransport
+from starlette.exceptions import HTTPException as StarletteHTTPException
+
+from app.core.request_id import (
+    RequestIDMiddleware,
+    get_request_id,
+    http_exception_handler,
+    validation_exception_handler,
+)
+
+
+def _build_app() -> FastAPI:
+    app = FastAPI()
+    app.add_middleware(RequestIDMiddleware)
+    app.add_exception_handler(StarletteHTTPException, http_exception_handler)
+    app.add_exception_handler(RequestValidationError, validation_exception_handler)
+
+    @app.get("/ok")
+    async def ok(request: Request):
+        return {"seen": get_request_id(request)}
+
+    @app.get("/missing")
+    async def missing():
+        raise HTTPException(status_code=404, detail="Not here")
+
+    @app.get("/typed")
+    async def typed(count: int):
+        return {"count": count}
+
+    return app
+
+
+@pytest.fixture
+async def client():
+    transport = ASGITransport(app=_build_app())
+    async with httpx.AsyncClient(transport=transport, base_url="http://test") as c:
+        yield c
+
+
+@pytest.mark.asyncio
+class TestRequestID:
+    async def test_incoming_id_is_echoed(self, client):
+        response = await client.get("/ok", headers={"X-Request-ID": "trace-123"})
+        assert response.headers["x-request-id"] == "trace-123"
+        assert response.json() == {"seen": "trace-123"}
+
+    async def test_id_generated_when_absent(self, client):
+        response = await client.get("/ok")
+        request_id = response.headers["x-request-id"]
+        assert len(request_id) == 32
+        assert response.json() == {"seen": request_id}
+
+    async def test_unsafe_incoming_id_is_replaced(self, client):
+        response = await client.get("/ok", headers={"X-Request-ID": "bad id\t<x>"})
+        assert response.headers["x-request-id"] != "bad id\t<x>"
+
+    async def test_error_body_carries_id(self, client):
+        response = await client.get("/missing", headers={"X-Request-ID": "trace-404"})
+        assert response.status_code == 404
+        assert response.headers["x-request-id"] == "trace-404"
+        assert response.json() == {"detail": "Not here", "request_id": "trace-404"}
+
+    async def test_validation_error_body_carries_id(self, client):
+        response = await client.get(
+            "/typed", params={"count": "x"}, headers={"X-Request-ID": "trace-422"}
+        )
+        assert response.status_code == 422
+        assert response.json()["request_id"] == "trace-422"
@@ -0,0 +1,24 @@
+"""Quota reset policy parsing tests"""
+
+from app.services.billing_service import (
+    RESET_PERIODIC,
+    RESET_ROLLOVER,
+    RESET_STANDING,
+    ResetPolicy,
+    parse_reset_policies,
+)
+
+
+def test_parse_reset_policies():
+    assert parse_reset_policies(
+        "storage_mb=standing, messages=rollover:100,api_calls=periodic"
+    ) == {
+        "storage_mb": ResetPolicy(RESET_STANDING),
+        "messages": ResetPolicy(RESET_ROLLOVER, rollover_cap=100),
+        "api_calls": ResetPolicy(RESET_PERIODIC),
+    }
+
+
+def test_invalid_entries_are_ignored():
+    spec = "storage_mb=forever,messages=rollover:x,=standing"
+    assert parse_reset_policies(spec) == {}
@@ -0,0 +1,135 @@
+"""Search debug mode scoring breakdown tests"""
+
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException
+
+from app.api.endpoints import search as search_endpoint
+from app.api.endpoints.search import SearchRequest, _score_breakdown, search
+
+HITS = [
+    {
+        "title": "Redis caching",
+        "content": "Cache responses in Redis",
+        "score": 0.82,
+        "text_score": 3.5,
+        "metric": "cosine",
+        "category": "caching",
+    },
+    {
+        "title": "Redis FAQ",
+        "content": "What is Redis?",
+        "score": 1.0,
+        "metric": "exact_match",
+        "via_fallback": True,
+    },
+]
+
+
+class StubKnowledgeService:
+    async def search_router(self, query, top_k, route, filters):
+        return {
+            "route": f"{route}->semantic",
+            "results": HITS[:top_k],
+            "meta": {"decided_route": "semantic", "fallback_attempts": 1},
+            "fallback_applied": True,
+        }
+
+
+async def _run_search(debug, is_superuser=False):
+    user = SimpleNamespace(
+        id="user-1", subscription_plan="pro", is_superuser=is_superuser
+    )
+    http_request = SimpleNamespace(state=SimpleNamespace())
+    return await search(
+        SearchRequest(
+            query="redis", route="auto", filters={"category": "caching"}, debug=debug
+        ),
+        http_request,
+        user,
+        user,
+        StubKnowledgeService(),
+        None,
+    )
+
+
+@pytest.mark.asyncio
+class TestSearchDebug:
+    async def test_debug_fields_present(self, monkeypatch, quota):
+        monkeypatch.setattr(search_endpoint.config, "environment", "development")
+        response = await _run_search(debug=True)
+
+        hybrid, exact = (result.debug for result in response.results)
+        assert hybrid["rank"] == 1
+        assert hybrid["vector_similarity"] == 0.82
+        assert hybrid["lexical_score"] == 3.5
+        assert hybrid["filter_matches"] == {"category": True}
+        assert hybrid["score_weights"] == {"lexical": 0.0, "vector": 1.0}
+        assert hybrid["adjustments"] == [
+            {
+                "type": "rerank",
+                "detail": "text candidate re-scored by vector similarity",
+                "from_score": 3.5,
+                "to_score": 0.82,
+            }
+        ]
+        assert exact["vector_similarity"] is None
+        assert exact["lexical_score"] == 1.0
+        assert exact["score_weights"] == {"lexical": 1.0, "vector": 0.0}
+        assert exact["adjustments"] == []
+        assert exact["via_fallback"] is True
+        assert exact["filter_matches"] == {"category": False}
+
+        assert response.debug_info["decided_route"] == "semantic"
+        assert response.debug_info["fallback_applied"] is True
+
+    async def test_debug_fields_absent_by_default(self, monkeypatch, quota):
+        monkeypatch.setattr(search_endpoint.config, "environment", "development")
+        response = await _run_search(debug=False)
+
+        assert response.debug_info is None
+        assert all(result.debug is None for result in response.results)
+
+    async def test_debug_refused_for_users_in_production(self, monkeypatch, quota):
+        monkeypatch.setattr(search_endpoint.config, "environment", "production")
+
+        with pytest.raises(HTTPException) as exc_info:
+            await _run_search(debug=True)
+
+        assert exc_info.value.status_code == 403
+        # Refused before anything was reserved
+        assert quota.reserved == 0
+
+    async def test_debug_allowed_for_admins_in_production(self, monkeypatch, quota):
+        monkeypatch.setattr(search_endpoint.config, "environment", "production")
+        response = await _run_search(debug=True, is_superuser=True)
+
+        assert response.results[0].debug is not None
+
+
+def test_filter_matches_read_hit_metadata():
+    hit = {
+        "score": 0.7,
+        "metric": "cosine",
+        "metadata": {"category": "caching", "language": "en"},
+    }
+
+    breakdown = _score_breakdown(
+        hit,
+        1,
+        {"category": "caching", "metadata.language": "en", "author": "ops"},
+    )
+
+    assert breakdown["filter_matches"] == {
+        "category": True,
+        "metadata.language": True,
+        "author": False,
+    }
+
+
+def test_broader_retrieval_hits_report_their_penalty():
+    breakdown = _score_breakdown({"score": 0.0, "metric": "fallback"}, 3, None)
+
+    assert breakdown["score_weights"] == {"lexical": 0.0, "vector": 0.0}
+    assert [a["type"] for a in breakdown["adjustments"]] == ["penalty"]
@@ -0,0 +1,66 @@
+"""Search response truncation indicator tests"""
+
+from types import SimpleNamespace
+
+import pytest
+
+from app.api.endpoints.search import SearchRequest, search
+from app.config import config
+
+
+class StubKnowledgeService:
+    """Returns a fixed page of results out of a larger candidate pool."""
+
+    def __init__(self, results, candidate_count):
+        self.results = results
+        self.candidate_count = candidate_count
+
+    async def search_router(self, query, top_k, route, filters):
+        return {
+            "route": f"{route}->exact",
+            "results": self.results[:top_k],
+            "meta": {"candidate_count": self.candidate_count},
+        }
+
+
+async def _run_search(knowledge_service, top_k):
+    user = SimpleNamespace(id="user-1", subscription_plan="pro")
+    http_request = SimpleNamespace(state=SimpleNamespace())
+    return await search(
+        SearchRequest(query="redis", route="exact", top_k=top_k),
+        http_request,
+        user,
+        user,
+        knowledge_service,
+        None,
+    )
+
+
+@pytest.mark.asyncio
+class TestSearchTruncation:
+    async def test_result_set_and_snippets_flagged(self, quota):
+        limit = config.search.max_snippet_chars
+        results = [
+            {"title": "long", "content": "x" * (limit + 10), "score": 0.9},
+            {"title": "short", "content": "brief", "score": 0.8},
+        ]
+        response = await _run_search(
+            StubKnowledgeService(results, candidate_count=312), top_k=2
+        )
+
+        assert response.truncated is True
+        assert response.total_results == 2
+        assert response.candidate_count == 312
+        assert response.results[0].truncated is True
+        assert len(response.results[0].content) == limit
+        assert response.results[1].truncated is False
+
+    async def test_complete_results_not_flagged(self, quota):
+        results = [{"title": "only", "content": "brief", "score": 0.9}]
+        response = await _run_search(
+            StubKnowledgeService(results, candidate_count=1), top_k=5
+        )
+
+        assert response.truncated is False
+        assert response.candidate_count == 1
+        assert response.results[0].truncated is False
@@ -0,0 +1,95 @@
+"""Production security posture checks"""
+
+import logging
+
+import pytest
+
+from app.config import (
+    ApplicationConfig,
+    AtlasVectorSearchConfig,
+    EmbeddingConfig,
+    GenerationConfig,
+    MongoConfig,
+    PostgreSQLConfig,
+    RedisConfig,
+    ScyllaConfig,
+    SearchConfig,
+)
+
+STRONG_KEY = "k" * 48
+
+
+def _config(
+    environment="production",
+    secret_key=STRONG_KEY,
+    secret_key_configured=True,
+    postgres_password="pg-" + "x" * 20,
+    mongo_password="mongo-" + "y" * 20,
+    hipaa_mode=True,
+    allow_insecure_production=False,
+    atlas_uri="",
+) -> ApplicationConfig:
+    return ApplicationConfig(
+        scylla=ScyllaConfig(),
+        redis=RedisConfig(password="redis-pass"),
+        postgresql=PostgreSQLConfig(
+            password=postgres_password,
+            secret_key=secret_key,
+            secret_key_configured=secret_key_configured,
+        ),
+        mongo=MongoConfig(password=mongo_password),
+        embedding=EmbeddingConfig(),
+        generation=GenerationConfig(),
+        atlas_search=AtlasVectorSearchConfig(atlas_uri=atlas_uri),
+        search=SearchConfig(),
+        environment=environment,
+        hipaa_mode=hipaa_mode,
+        allow_insecure_production=allow_insecure_production,
+    )
+
+
+def test_secure_production_config_starts():
+    posture = _config().enforce_security_posture()
+    assert posture["valid"] is True
+
+
+@pytest.mark.parametrize(
+    "overrides, expected",
+    [
+        ({"secret_key_configured": False}, "SECRET_KEY is not set"),
+        ({"secret_key": "your-secret-key-here"}, "SECRET_KEY is weak"),
+        ({"secret_key": "short"}, "SECRET_KEY is weak"),
+        ({"postgres_password": "secure_password"}, "POSTGRES_PASSWORD"),
+        ({"mongo_password": "example"}, "MONGO_PASSWORD"),
+        ({"hipaa_mode": False}, "HIPAA_MODE is off"),
+    ],
+)
+def test_production_refuses_misconfiguration(overrides, expected):
+    config = _config(**overrides)
+
+    posture = config.validate_security_posture()
+    assert any(expected in issue for issue in posture["issues"])
+
+    with pytest.raises(RuntimeError, match=expected):
+        config.enforce_security_posture()
+
+
+def test_atlas_uri_exempts_local_mongo_password():
+    config = _config(mongo_password="example", atlas_uri="mongodb+srv://cluster.test")
+    assert config.validate_security_posture()["valid"] is True
+
+
+def test_override_allows_insecure_production_start():
+    config = _config(hipaa_mode=False, allow_insecure_production=True)
+    posture = config.enforce_security_posture()
+    assert posture["valid"] is False
+
+
+def test_development_only_reports(caplog):
+    config = _config(environment="development", postgres_password="secure_password")
+
+    with caplog.at_level(logging.WARNING, logger="app.config"):
+        posture = config.enforce_security_posture()
+
+    assert posture["valid"] is False
+    assert any("POSTGRES_PASSWORD" in record.message for record in caplog.records)